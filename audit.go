@@ -0,0 +1,229 @@
+package libfun
+
+import (
+	"context"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tychoish/grip"
+	"github.com/tychoish/grip/level"
+	"github.com/tychoish/grip/message"
+	"github.com/tychoish/grip/send"
+)
+
+// DefaultSecretPattern matches the names of flags and environment
+// variables that commonly hold secrets, and is the default for
+// CommandAudit.Secrets.
+var DefaultSecretPattern = regexp.MustCompile(`(?i)(passw(or)?d|secret|token|api[-_]?key|credential|private[-_]?key)`)
+
+// Redacted replaces secrets in audit records.
+const Redacted = "[REDACTED]"
+
+// CommandAudit logs a message.Fields record, with secrets redacted,
+// for every execution of a command (including retries and pipeline
+// stages). The zero value is valid, and logs to the grip logger
+// attached to the context.
+type CommandAudit struct {
+	// Sender, when specified, receives the records rather than
+	// the grip logger.
+	Sender send.Sender
+	// SuccessLevel and FailureLevel default to level.Debug and
+	// level.Error.
+	SuccessLevel level.Priority
+	FailureLevel level.Priority
+	Tags         []string
+	// Secrets matches the names of flags (e.g. --token) and
+	// environment variables whose values are redacted, and
+	// defaults to DefaultSecretPattern. Passwords in URLs are
+	// always redacted.
+	Secrets *regexp.Regexp
+	// Redact, when specified, is applied to every argument,
+	// environment value, and error after the other rules.
+	Redact func(string) string
+}
+
+type auditCtxKey struct{}
+
+// WithAudit attaches a CommandAudit to the context, for all commands
+// (including RunCommand and Ripgrep) run with the context.
+func WithAudit(ctx context.Context, audit *CommandAudit) context.Context {
+	return context.WithValue(ctx, auditCtxKey{}, audit)
+}
+
+func (c Command) audit(ctx context.Context) *CommandAudit {
+	if c.Audit != nil {
+		return c.Audit
+	}
+	if audit, ok := ctx.Value(auditCtxKey{}).(*CommandAudit); ok {
+		return audit
+	}
+	return nil
+}
+
+// redaction returns the CommandAudit that redacts the command in
+// logs, which is the default CommandAudit when there is none.
+func (c Command) redaction(ctx context.Context) *CommandAudit {
+	if audit := c.audit(ctx); audit != nil {
+		return audit
+	}
+	return &CommandAudit{}
+}
+
+func (ca *CommandAudit) record(ctx context.Context, c Command, report *execReport, err error) {
+	code := exitCode(err)
+	fields := message.Fields{
+		"cmd":          c.Args[0],
+		"args":         ca.redactArgs(c.Args[1:]),
+		"dir":          TryExpandHomedir(c.Dir),
+		"dur":          report.Usage.Wall,
+		"exit_code":    code,
+		"stdout_bytes": report.Usage.StdoutBytes,
+		"stderr_bytes": report.Usage.StderrBytes,
+	}
+	if env := ca.envDiff(c.Env); len(env) > 0 {
+		fields["env"] = env
+	}
+	if tags := append(slices.Clone(c.Tags), ca.Tags...); len(tags) > 0 {
+		fields["tags"] = tags
+	}
+
+	priority := ca.SuccessLevel
+	if priority == level.Invalid {
+		priority = level.Debug
+	}
+
+	if err != nil {
+		fields["err"] = ca.redactError(c, err)
+		kind, sig := classifyExit(err, code)
+		if kind != nil {
+			fields["kind"] = kind.Error()
+		}
+		if sig != 0 {
+			fields["signal"] = sig.String()
+		}
+
+		priority = ca.FailureLevel
+		if priority == level.Invalid {
+			priority = level.Error
+		}
+	}
+
+	msg := message.MakeFields(fields)
+	msg.SetPriority(priority)
+
+	if ca.Sender != nil {
+		ca.Sender.Send(msg)
+		return
+	}
+	grip.Context(ctx).Send(msg)
+}
+
+func (ca *CommandAudit) secrets() *regexp.Regexp {
+	if ca.Secrets == nil {
+		return DefaultSecretPattern
+	}
+	return ca.Secrets
+}
+
+func (ca *CommandAudit) redact(in string) string {
+	if u, err := url.Parse(in); err == nil && u.User != nil {
+		in = u.Redacted()
+	}
+	if ca.Redact != nil {
+		in = ca.Redact(in)
+	}
+	return in
+}
+
+// redactArgs redacts the values of flags with secret names, both in
+// the "--flag=value" and "--flag value" forms, as well as any
+// arguments that the redact rules identify. Values may begin with a
+// single "-", but not with "--".
+func (ca *CommandAudit) redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	secretValue := false
+	for idx, arg := range args {
+		switch {
+		case secretValue && !strings.HasPrefix(arg, "--"):
+			out[idx] = Redacted
+			secretValue = false
+			continue
+		case strings.HasPrefix(arg, "-"):
+			name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+			isSecret := name != "" && ca.secrets().MatchString(name)
+			if isSecret && hasValue {
+				out[idx] = arg[:strings.Index(arg, "=")+1] + Redacted
+				secretValue = false
+				continue
+			}
+			secretValue = isSecret
+		default:
+			secretValue = false
+		}
+		out[idx] = ca.redact(arg)
+	}
+	return out
+}
+
+// redactCommand returns the command string with its arguments
+// redacted, as in records.
+func (ca *CommandAudit) redactCommand(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return strings.Join(append([]string{args[0]}, ca.redactArgs(args[1:])...), " ")
+}
+
+// redactError returns the message of an error from the command, with
+// the arguments and environment values that records redact replaced,
+// as errors like ErrOutput include the command and its output.
+func (ca *CommandAudit) redactError(c Command, err error) string {
+	var pairs []string
+	replace := func(raw, redacted string) {
+		if raw == "" || raw == redacted {
+			return
+		}
+		pairs = append(pairs, raw, redacted)
+		// ErrOutput quotes the command and output.
+		if quoted := strconv.Quote(raw); quoted[1:len(quoted)-1] != raw {
+			pairs = append(pairs, quoted[1:len(quoted)-1], redacted)
+		}
+	}
+
+	if len(c.Args) > 0 {
+		for idx, arg := range ca.redactArgs(c.Args[1:]) {
+			replace(c.Args[idx+1], arg)
+		}
+	}
+	for key, value := range c.Env {
+		if ca.secrets().MatchString(key) {
+			replace(value, Redacted)
+		} else {
+			replace(value, ca.redact(value))
+		}
+	}
+
+	return ca.redact(strings.NewReplacer(pairs...).Replace(err.Error()))
+}
+
+// envDiff returns the variables in env that are not set to the same
+// value in the current process, with the values of secrets redacted.
+func (ca *CommandAudit) envDiff(env map[string]string) map[string]string {
+	out := map[string]string{}
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		if current, ok := os.LookupEnv(key); ok && current == env[key] {
+			continue
+		}
+		if ca.secrets().MatchString(key) {
+			out[key] = Redacted
+			continue
+		}
+		out[key] = ca.redact(env[key])
+	}
+	return out
+}
@@ -0,0 +1,288 @@
+package libfun
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/grip"
+	"github.com/tychoish/grip/message"
+)
+
+// CommandCache stores the output of successful commands on disk, so
+// that repeated invocations of expensive commands can be served
+// without running them. Entries are keyed on the arguments,
+// environment, working directory, and standard input of the
+// command. The zero value is valid, and stores entries in
+// DefaultCacheDir without expiration or size limits.
+//
+// Because the key includes the standard input, the Stdin or Input of
+// a cached command is read into memory before the command runs, so
+// commands with large or unbounded input should not be cached.
+type CommandCache struct {
+	// Dir is the directory that holds cache entries, and
+	// defaults to DefaultCacheDir().
+	Dir string
+	// TTL, when positive, is the maximum age of an entry;
+	// expired entries are never served.
+	TTL time.Duration
+	// MaxSize, when positive, limits the total size of all
+	// entries in bytes. The oldest entries are evicted when the
+	// limit is exceeded.
+	MaxSize int64
+	// MaxEntrySize, when positive, is the largest output that
+	// will be cached.
+	MaxEntrySize int64
+}
+
+// DefaultCacheDir returns the directory for command cache entries,
+// within the user's XDG cache directory.
+func DefaultCacheDir() string {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = filepath.Join(Homedir(), ".cache")
+	}
+	return filepath.Join(base, "libfun", "commands")
+}
+
+func (cc *CommandCache) dir() string {
+	if cc.Dir == "" {
+		return DefaultCacheDir()
+	}
+	return TryExpandHomedir(cc.Dir)
+}
+
+// Lines returns the lines (or records, when the command specifies
+// Split) of the command's output from the cache,
+// running the command and caching the output on a cache miss.
+func (cc *CommandCache) Lines(ctx context.Context, c Command) (iter.Seq[string], error) {
+	out, err := cc.Output(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(out, c.Split)
+}
+
+// Output returns the command's output from the cache, running the
+// command and caching the output on a cache miss. Failed commands
+// are never cached. The command's standard input, if any, is read
+// into memory to compute the key before the command runs. Caching
+// is best-effort: when the output cannot be written to the cache,
+// the error is logged and the output is returned.
+func (cc *CommandCache) Output(ctx context.Context, c Command) ([]byte, error) {
+	c.Cache = nil
+
+	key, err := cc.key(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	if out, ok := cc.get(key); ok {
+		return out, nil
+	}
+
+	out, err := c.Output(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cc.put(key, out); err != nil {
+		grip.Context(ctx).Warning(message.Fields{"cache": cc.dir(), "cmd": c.Args[0], "err": err})
+	}
+
+	return out, nil
+}
+
+// Invalidate removes the cache entry for the command, if one
+// exists. As with Output, the command's standard input is read
+// completely to compute the key.
+func (cc *CommandCache) Invalidate(c Command) error {
+	key, err := cc.key(&c)
+	if err != nil {
+		return err
+	}
+	return ignoreNotExist(os.Remove(cc.path(key)))
+}
+
+// Clear removes all entries from the cache.
+func (cc *CommandCache) Clear() error { return ignoreNotExist(os.RemoveAll(cc.dir())) }
+
+// Prune removes expired entries from the cache, and then removes the
+// oldest entries until the cache is within its size limit.
+func (cc *CommandCache) Prune() error {
+	entries, err := os.ReadDir(cc.dir())
+	if err != nil {
+		return ignoreNotExist(err)
+	}
+
+	type entry struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+
+	var (
+		live  []entry
+		total int64
+		ec    = &erc.Collector{}
+	)
+
+	for _, de := range entries {
+		// temporary files belong to in-progress writes.
+		if strings.HasPrefix(de.Name(), ".tmp") {
+			continue
+		}
+
+		info, err := de.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(cc.dir(), de.Name())
+
+		if cc.expired(info.ModTime()) {
+			ec.Push(ignoreNotExist(os.Remove(path)))
+			continue
+		}
+
+		live = append(live, entry{path: path, size: info.Size(), mod: info.ModTime()})
+		total += info.Size()
+	}
+
+	if cc.MaxSize <= 0 || total <= cc.MaxSize {
+		return ec.Resolve()
+	}
+
+	slices.SortFunc(live, func(a, b entry) int { return a.mod.Compare(b.mod) })
+	for _, e := range live {
+		if total <= cc.MaxSize {
+			break
+		}
+		ec.Push(ignoreNotExist(os.Remove(e.path)))
+		total -= e.size
+	}
+
+	return ec.Resolve()
+}
+
+func (cc *CommandCache) path(key string) string { return filepath.Join(cc.dir(), key) }
+
+func (cc *CommandCache) expired(ts time.Time) bool {
+	return cc.TTL > 0 && time.Since(ts) > cc.TTL
+}
+
+func (cc *CommandCache) get(key string) ([]byte, bool) {
+	info, err := os.Stat(cc.path(key))
+	if err != nil || cc.expired(info.ModTime()) {
+		return nil, false
+	}
+
+	out, err := os.ReadFile(cc.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	return out, true
+}
+
+func (cc *CommandCache) put(key string, out []byte) error {
+	if cc.MaxEntrySize > 0 && int64(len(out)) > cc.MaxEntrySize {
+		return nil
+	}
+
+	if err := os.MkdirAll(cc.dir(), 0o700); err != nil {
+		return err
+	}
+
+	// write to a temporary file and rename it into place so that
+	// concurrent readers never observe partial entries.
+	tmp, err := os.CreateTemp(cc.dir(), ".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(out)
+	err = erc.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), cc.path(key))
+	}
+	if err != nil {
+		return erc.Join(err, ignoreNotExist(os.Remove(tmp.Name())))
+	}
+
+	if cc.MaxSize > 0 {
+		return cc.Prune()
+	}
+
+	return nil
+}
+
+// key computes the cache key for the command. When the command has
+// standard input, the input is read completely and replaced with an
+// equivalent reader.
+func (cc *CommandCache) key(c *Command) (string, error) {
+	hash := sha256.New()
+	write := func(s string) { _, _ = io.WriteString(hash, s); _, _ = hash.Write([]byte{0}) }
+
+	write("args")
+	write(strconv.Itoa(len(c.Args)))
+	for _, arg := range c.Args {
+		write(arg)
+	}
+
+	dir := TryExpandHomedir(c.Dir)
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	write("dir")
+	write(dir)
+
+	write("env")
+	write(strconv.Itoa(len(c.Env)))
+	for _, key := range slices.Sorted(maps.Keys(c.Env)) {
+		write(key)
+		write(c.Env[key])
+	}
+
+	write("stdin")
+	if c.Input != nil {
+		var buf bytes.Buffer
+		for chunk := range c.Input {
+			buf.Write(chunk)
+		}
+		c.Stdin, c.Input = &buf, nil
+	}
+	if c.Stdin != nil {
+		input, err := io.ReadAll(c.Stdin)
+		if err != nil {
+			return "", err
+		}
+		c.Stdin = bytes.NewReader(input)
+		inputHash := sha256.Sum256(input)
+		write(hex.EncodeToString(inputHash[:]))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func ignoreNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package libfun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+)
+
+// countingCommand returns a command that reports the number of times
+// that it has run.
+func countingCommand(t *testing.T) Command {
+	counter := filepath.Join(t.TempDir(), "count")
+	return MakeCommand("sh", "-c", fmt.Sprintf(`echo x >> %[1]s; wc -l < %[1]s | tr -d ' '`, counter))
+}
+
+func TestCommandCache(t *testing.T) {
+	t.Run("Hit", func(t *testing.T) {
+		cc := &CommandCache{Dir: t.TempDir()}
+		cmd := countingCommand(t)
+		for range 3 {
+			seq, err := cc.Lines(testContext(t), cmd)
+			assert.NotError(t, err)
+			assert.EqualItems(t, irt.Collect(seq), []string{"1"})
+		}
+	})
+	t.Run("CommandField", func(t *testing.T) {
+		cmd := countingCommand(t)
+		cmd.Cache = &CommandCache{Dir: t.TempDir()}
+		for range 3 {
+			out, err := cmd.Output(testContext(t))
+			assert.NotError(t, err)
+			assert.Equal(t, string(out), "1\n")
+		}
+	})
+	t.Run("KeyedOnDirEnvAndInput", func(t *testing.T) {
+		cc := &CommandCache{Dir: t.TempDir()}
+		cmd := countingCommand(t)
+		run := func(cmd Command) string {
+			out, err := cc.Output(testContext(t), cmd)
+			assert.NotError(t, err)
+			return strings.TrimSpace(string(out))
+		}
+
+		check.Equal(t, run(cmd), "1")
+
+		withDir := cmd
+		withDir.Dir = t.TempDir()
+		check.Equal(t, run(withDir), "2")
+		check.Equal(t, run(withDir), "2")
+
+		withEnv := cmd
+		withEnv.Env = map[string]string{"KEY": "value"}
+		check.Equal(t, run(withEnv), "3")
+
+		withInput := cmd
+		withInput.Stdin = strings.NewReader("one")
+		check.Equal(t, run(withInput), "4")
+		withInput.Stdin = strings.NewReader("one")
+		check.Equal(t, run(withInput), "4")
+		withInput.Stdin = strings.NewReader("two")
+		check.Equal(t, run(withInput), "5")
+	})
+	t.Run("Input", func(t *testing.T) {
+		cc := &CommandCache{Dir: t.TempDir()}
+		cmd := MakeCommand("sort")
+		cmd.Stdin = strings.NewReader("b\na\n")
+		seq, err := cc.Lines(testContext(t), cmd)
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"a", "b"})
+	})
+	t.Run("TTL", func(t *testing.T) {
+		cc := &CommandCache{Dir: t.TempDir(), TTL: time.Minute}
+		cmd := countingCommand(t)
+		out, err := cc.Output(testContext(t), cmd)
+		assert.NotError(t, err)
+		check.Equal(t, string(out), "1\n")
+
+		entries, err := os.ReadDir(cc.Dir)
+		assert.NotError(t, err)
+		assert.Equal(t, len(entries), 1)
+		old := time.Now().Add(-time.Hour)
+		assert.NotError(t, os.Chtimes(filepath.Join(cc.Dir, entries[0].Name()), old, old))
+
+		out, err = cc.Output(testContext(t), cmd)
+		assert.NotError(t, err)
+		check.Equal(t, string(out), "2\n")
+	})
+	t.Run("Invalidate", func(t *testing.T) {
+		cc := &CommandCache{Dir: t.TempDir()}
+		cmd := countingCommand(t)
+		_, err := cc.Output(testContext(t), cmd)
+		assert.NotError(t, err)
+		assert.NotError(t, cc.Invalidate(cmd))
+		out, err := cc.Output(testContext(t), cmd)
+		assert.NotError(t, err)
+		check.Equal(t, string(out), "2\n")
+
+		assert.NotError(t, cc.Clear())
+		out, err = cc.Output(testContext(t), cmd)
+		assert.NotError(t, err)
+		check.Equal(t, string(out), "3\n")
+	})
+	t.Run("Failures", func(t *testing.T) {
+		cc := &CommandCache{Dir: t.TempDir()}
+		_, err := cc.Output(testContext(t), MakeCommand("false"))
+		assert.Error(t, err)
+		entries, err := os.ReadDir(cc.Dir)
+		check.True(t, os.IsNotExist(err) || len(entries) == 0)
+	})
+	t.Run("Unwritable", func(t *testing.T) {
+		cc := &CommandCache{Dir: "/proc/libfun-cache"}
+		out, err := cc.Output(testContext(t), MakeCommand("echo", "hi"))
+		assert.NotError(t, err)
+		check.Equal(t, string(out), "hi\n")
+	})
+	t.Run("MaxSize", func(t *testing.T) {
+		cc := &CommandCache{Dir: t.TempDir(), MaxSize: 10}
+		for idx := range 5 {
+			_, err := cc.Output(testContext(t), MakeCommand("echo", fmt.Sprint("entry-", idx)))
+			assert.NotError(t, err)
+		}
+		entries, err := os.ReadDir(cc.Dir)
+		assert.NotError(t, err)
+		check.Equal(t, len(entries), 1)
+	})
+	t.Run("MaxEntrySize", func(t *testing.T) {
+		cc := &CommandCache{Dir: t.TempDir(), MaxEntrySize: 2}
+		cmd := countingCommand(t)
+		_, err := cc.Output(testContext(t), MakeCommand("echo", "too long"))
+		assert.NotError(t, err)
+		entries, err := os.ReadDir(cc.Dir)
+		assert.NotError(t, err)
+		check.Equal(t, len(entries), 0)
+
+		_, err = cc.Output(testContext(t), cmd)
+		assert.NotError(t, err)
+		out, err := cc.Output(testContext(t), cmd)
+		assert.NotError(t, err)
+		check.Equal(t, string(out), "1\n")
+	})
+	t.Run("DefaultDir", func(t *testing.T) {
+		t.Setenv("XDG_CACHE_HOME", "/tmp/libfun-xdg")
+		check.Equal(t, DefaultCacheDir(), "/tmp/libfun-xdg/libfun/commands")
+	})
+}
@@ -1,58 +1,298 @@
 package libfun
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"iter"
+	"os/exec"
+	"syscall"
 
+	"github.com/tychoish/fun/ers"
 	"github.com/tychoish/fun/irt"
 	"github.com/tychoish/jasper"
-	"github.com/tychoish/jasper/util"
 )
 
+// MaxErrOutputSize is the maximum number of bytes of standard output
+// and standard error that an ErrOutput retains. When the output of a
+// command exceeds this limit, only the last MaxErrOutputSize bytes
+// (which typically contain the cause of the failure) are kept.
+const MaxErrOutputSize = 4 * 1024
+
+// These errors classify the failures of commands, and are carried
+// by ErrOutput as its Kind, so that errors.Is (and ers.Is) can
+// distinguish between failures, for example:
+//
+//	if errors.Is(err, libfun.ErrCommandNotFound) { ... }
+const (
+	// ErrCommandNotFound is the kind of failures where the
+	// program does not exist.
+	ErrCommandNotFound ers.Error = "command not found"
+	// ErrNonZeroExit is the kind of failures where the process
+	// exited with a non-zero exit code, which is available as
+	// the ExitCode of the ErrOutput.
+	ErrNonZeroExit ers.Error = "command exited with non-zero exit code"
+	// ErrSignaled is the kind of failures where the process was
+	// terminated by a signal, which is available as the Signal of
+	// the ErrOutput.
+	ErrSignaled ers.Error = "command terminated by signal"
+	// ErrCommandTimeout is the kind of failures where the
+	// command's Timeout or the context's deadline expired.
+	ErrCommandTimeout ers.Error = "command timed out"
+	// ErrCommandCanceled is the kind of failures where the context
+	// was canceled while the command ran.
+	ErrCommandCanceled ers.Error = "command canceled"
+	// ErrOutputLimitExceeded is the kind of failures where the
+	// command's output exceeded its OutputLimits, and the
+	// process was terminated. The output of the ErrOutput is
+	// truncated at the limit.
+	ErrOutputLimitExceeded ers.Error = "command output exceeded limit"
+	// ErrCommandStalled is the kind of failures where the command
+	// produced no output for longer than the StallAfter period
+	// of its CommandProgress, and was terminated.
+	ErrCommandStalled ers.Error = "command stalled"
+)
+
+// ErrOutput is returned by the command helpers when a command
+// fails, and captures the (possibly truncated) output of the command
+// along with its exit code. The underlying error is available via
+// errors.Unwrap, errors.Is, and errors.As, and errors.Is also
+// matches the Kind of the failure.
 type ErrOutput struct {
-	Cmd string
-	Err string
-	Out string
+	Cmd      string
+	Err      string
+	Out      string
+	ExitCode int
+	// Signal is the signal that terminated the process, if
+	// any. When Kind is ErrCommandTimeout, ErrCommandCanceled,
+	// ErrOutputLimitExceeded, or ErrCommandStalled, it reports
+	// whether the process exited after SIGTERM, or had to be
+	// killed with SIGKILL (see Command.KillGrace).
+	Signal syscall.Signal
+	// Kind classifies the failure, and is one of
+	// ErrCommandNotFound, ErrNonZeroExit, ErrSignaled,
+	// ErrCommandTimeout, ErrCommandCanceled,
+	// ErrOutputLimitExceeded, or ErrCommandStalled, or nil when
+	// the failure has another cause (e.g. the program is not
+	// executable).
+	Kind error
+	// Line is the line of the script that failed, for scripts
+	// run with RunScript, when the shell reports it.
+	Line  int
+	Cause error
 }
 
 func (e *ErrOutput) Error() string {
-	return fmt.Sprintf("cmd: %q; output: %q; error: %q", e.Cmd, e.Out, e.Err)
+	if e.Line > 0 {
+		return fmt.Sprintf("cmd: %q; line: %d; exit code: %d; output: %q; error: %q: %v", e.Cmd, e.Line, e.ExitCode, e.Out, e.Err, e.Cause)
+	}
+	return fmt.Sprintf("cmd: %q; exit code: %d; output: %q; error: %q: %v", e.Cmd, e.ExitCode, e.Out, e.Err, e.Cause)
 }
 
-func RunCommand(ctx context.Context, cmd string) (iter.Seq[string], error) {
-	var stdoutBuf bytes.Buffer
-	var stderrBuf bytes.Buffer
+func (e *ErrOutput) Unwrap() error { return e.Cause }
 
-	err := jasper.Context(ctx).
-		CreateCommand(ctx).
-		Append(cmd).
-		SetOutputWriter(util.NewLocalBuffer(&stdoutBuf)).
-		SetErrorWriter(util.NewLocalBuffer(&stderrBuf)).
-		Run(ctx)
+// Is reports if the target is the Kind of the failure.
+func (e *ErrOutput) Is(target error) bool { return e.Kind != nil && e.Kind == target }
+
+func newErrOutput(cmd string, err error, stdout, stderr string) error {
+	if err == nil {
+		return nil
+	}
+
+	eo := &ErrOutput{
+		Cmd:      cmd,
+		Out:      truncateOutput(stdout),
+		Err:      truncateOutput(stderr),
+		ExitCode: exitCode(err),
+		Cause:    err,
+	}
+	eo.Kind, eo.Signal = classifyExit(err, eo.ExitCode)
+
+	return eo
+}
+
+// classifyExit determines the Kind of a failed command, and the
+// signal that terminated it, if any. Output limits, stalls, and
+// context errors take precedence, as processes are killed when they
+// exceed their limits, stall, or the context expires.
+func classifyExit(err error, code int) (error, syscall.Signal) {
+	var sig syscall.Signal
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		if ws, ok := ee.Sys().(interface {
+			Signaled() bool
+			Signal() syscall.Signal
+		}); ok && ws.Signaled() {
+			sig = ws.Signal()
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrOutputLimitExceeded):
+		return ErrOutputLimitExceeded, sig
+	case errors.Is(err, ErrCommandStalled):
+		return ErrCommandStalled, sig
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrCommandTimeout, sig
+	case errors.Is(err, context.Canceled):
+		return ErrCommandCanceled, sig
+	case errors.Is(err, exec.ErrNotFound):
+		return ErrCommandNotFound, 0
+	case code > 0:
+		return ErrNonZeroExit, 0
+	case sig != 0:
+		return ErrSignaled, sig
+	}
+
+	// programs specified by path, that do not exist, fail with a
+	// path error rather than exec.ErrNotFound.
+	var pe *fs.PathError
+	if errors.As(err, &pe) && pe.Op == "fork/exec" && errors.Is(pe, fs.ErrNotExist) {
+		return ErrCommandNotFound, 0
+	}
+
+	return nil, 0
+}
+
+func truncateOutput(in string) string {
+	if len(in) <= MaxErrOutputSize {
+		return in
+	}
+
+	return fmt.Sprintf("[%d bytes truncated]...%s", len(in)-MaxErrOutputSize, in[len(in)-MaxErrOutputSize:])
+}
+
+// exitCode returns the exit code of the process that produced the
+// error (e.g. an *exec.ExitError), or -1 if the error did not come
+// from an exited process.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+// RunCommand runs the command using the jasper.Manager attached to
+// the context, and returns an iterator over the lines of the
+// command's standard output. If the command fails the error is an
+// *ErrOutput that contains the output of the command.
+//
+// The command is split into arguments with ParseCommand, which
+// follows the same quoting rules as jasper's Command.Append. Unlike
+// jasper, which runs an empty command when the string cannot be
+// split (e.g. with an unterminated quote), RunCommand (and the other
+// functions that take command strings) returns the parsing error.
+func RunCommand(ctx context.Context, cmd string) (iter.Seq[string], error) {
+	c, err := ParseCommand(cmd)
 	if err != nil {
 		return nil, err
 	}
 
-	return irt.ReadLines(&stdoutBuf), nil
+	return c.Lines(ctx)
 }
 
+// RunCommandWithInput is the same as RunCommand, but the contents of
+// the reader are passed to the command's standard input.
 func RunCommandWithInput(ctx context.Context, cmd string, stdin io.Reader) (iter.Seq[string], error) {
-	var stdoutBuf bytes.Buffer
-	var stderrBuf bytes.Buffer
+	c, err := ParseCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	c.Stdin = stdin
+
+	return c.Lines(ctx)
+}
+
+// RunCommandWithLines is the same as RunCommandWithInput, but writes
+// the strings in the sequence to the command's standard input, one
+// per line, as the sequence produces them. This makes it possible to
+// pipe the output of operations like Ripgrep or FsWalkStream into
+// programs like sort or xargs without collecting the output first.
+func RunCommandWithLines(ctx context.Context, cmd string, input iter.Seq[string]) (iter.Seq[string], error) {
+	c, err := ParseCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	c.Input = InputLines(input)
+
+	return c.Lines(ctx)
+}
+
+// RunCommandSplit is the same as RunCommand, but divides the
+// command's output into records with the split function (e.g.
+// ScanNull, ScanDelimiter, ScanFixed, ScanRegexp, or ScanChunks)
+// rather than into lines.
+func RunCommandSplit(ctx context.Context, cmd string, split bufio.SplitFunc) (iter.Seq[string], error) {
+	c, err := ParseCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	c.Split = split
+
+	return c.Lines(ctx)
+}
+
+// RunCommandWithInputSplit is the same as RunCommandWithInput, but
+// divides the command's output into records with the split function
+// rather than into lines.
+func RunCommandWithInputSplit(ctx context.Context, cmd string, stdin io.Reader, split bufio.SplitFunc) (iter.Seq[string], error) {
+	c, err := ParseCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	c.Stdin = stdin
+	c.Split = split
+
+	return c.Lines(ctx)
+}
+
+// RunManagedCommand is the same as RunCommand, but runs the command
+// using the provided jasper.Manager rather than the manager attached
+// to the context.
+func RunManagedCommand(ctx context.Context, jpm jasper.Manager, cmd string) (iter.Seq[string], error) {
+	c, err := ParseCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	c.Manager = jpm
+
+	return c.Lines(ctx)
+}
 
-	err := jasper.Context(ctx).
-		CreateCommand(ctx).
-		Append(cmd).
-		SetOutputWriter(util.NewLocalBuffer(&stdoutBuf)).
-		SetErrorWriter(util.NewLocalBuffer(&stderrBuf)).
-		SetInput(stdin).
-		Run(ctx)
+// RunManagedCommandWithInput is the same as RunCommandWithInput, but
+// runs the command using the provided jasper.Manager rather than the
+// manager attached to the context.
+func RunManagedCommandWithInput(ctx context.Context, jpm jasper.Manager, cmd string, stdin io.Reader) (iter.Seq[string], error) {
+	c, err := ParseCommand(cmd)
 	if err != nil {
 		return nil, err
 	}
+	c.Manager = jpm
+	c.Stdin = stdin
+
+	return c.Lines(ctx)
+}
+
+// StreamCommand runs the command using the jasper.Manager attached
+// to the context and yields lines from the command's standard output
+// as they are written, rather than after the command exits. When the
+// consumer stops iterating the process is terminated. If the command
+// fails, the final element of the sequence is an *ErrOutput that
+// contains the command's standard error.
+func StreamCommand(ctx context.Context, cmd string) iter.Seq2[string, error] {
+	c, err := ParseCommand(cmd)
+	if err != nil {
+		return irt.Two("", err)
+	}
 
-	return irt.ReadLines(&stdoutBuf), nil
+	return c.Stream(ctx)
 }
@@ -0,0 +1,328 @@
+package libfun
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+	"github.com/tychoish/fun/irt"
+	"github.com/tychoish/jasper"
+	"github.com/tychoish/jasper/options"
+)
+
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+	return jasper.WithManager(t.Context(), jasper.NewManager(jasper.ManagerOptionDefaults()))
+}
+
+func errOutput(t *testing.T, err error) *ErrOutput {
+	t.Helper()
+	var eo *ErrOutput
+	assert.True(t, errors.As(err, &eo))
+	return eo
+}
+
+func TestRunCommand(t *testing.T) {
+	t.Run("Output", func(t *testing.T) {
+		seq, err := RunCommand(testContext(t), "echo hello world")
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"hello world"})
+	})
+	t.Run("Input", func(t *testing.T) {
+		seq, err := RunCommandWithInput(testContext(t), "sort", strings.NewReader("b\nc\na\n"))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"a", "b", "c"})
+	})
+	t.Run("ErrOutput", func(t *testing.T) {
+		seq, err := RunCommand(testContext(t), "sh -c 'echo out; echo err >&2; exit 3'")
+		assert.Error(t, err)
+		assert.True(t, seq == nil)
+
+		var eo *ErrOutput
+		assert.True(t, errors.As(err, &eo))
+		check.Equal(t, eo.ExitCode, 3)
+		check.Equal(t, eo.Out, "out\n")
+		check.Equal(t, eo.Err, "err\n")
+		check.Substring(t, eo.Cmd, "exit 3")
+
+		var exitErr *exec.ExitError
+		check.True(t, errors.As(err, &exitErr))
+		check.True(t, errors.Unwrap(err) != nil)
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := RunCommand(testContext(t), "libfun-command-does-not-exist")
+		var eo *ErrOutput
+		assert.True(t, errors.As(err, &eo))
+		check.Equal(t, eo.ExitCode, -1)
+		check.ErrorIs(t, err, exec.ErrNotFound)
+	})
+	t.Run("TruncatedOutput", func(t *testing.T) {
+		_, err := RunCommand(testContext(t), "sh -c 'head -c 10000 /dev/zero | tr \"\\0\" x; exit 1'")
+		var eo *ErrOutput
+		assert.True(t, errors.As(err, &eo))
+		check.True(t, len(eo.Out) < 2*MaxErrOutputSize)
+		check.Substring(t, eo.Out, "bytes truncated")
+	})
+	t.Run("Manager", func(t *testing.T) {
+		jpm := jasper.NewManager(jasper.ManagerOptionDefaults())
+
+		// no manager is attached to this context
+		seq, err := RunManagedCommand(t.Context(), jpm, "echo hello world")
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"hello world"})
+
+		seq, err = RunManagedCommandWithInput(t.Context(), jpm, "sort", strings.NewReader("b\na\n"))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"a", "b"})
+
+		procs, err := jpm.List(t.Context(), options.All)
+		assert.NotError(t, err)
+		check.Equal(t, len(procs), 2)
+	})
+	t.Run("Quoting", func(t *testing.T) {
+		// commands split into the same arguments as they did
+		// when RunCommand passed them to jasper.
+		for _, cmd := range []string{
+			"echo hello world",
+			"echo 'hello world'",
+			`echo "hello world" again`,
+			`sh -c 'echo "$0"' "quoted arg"`,
+			`printf '%s\n' "a b" c\ d`,
+			"echo  extra   spaces\ttabs",
+			`echo "" ''`,
+			"echo # comment",
+		} {
+			c, err := ParseCommand(cmd)
+			assert.NotError(t, err)
+
+			opts, err := jasper.NewCommand().Append(cmd).Export()
+			assert.NotError(t, err)
+			assert.Equal(t, len(opts), 1)
+			check.EqualItems(t, c.Args, opts[0].Args)
+		}
+
+		_, err := RunCommand(testContext(t), "echo 'unterminated")
+		check.Error(t, err)
+	})
+}
+
+func TestStreamCommand(t *testing.T) {
+	t.Run("Lines", func(t *testing.T) {
+		lines, err := erc.FromIteratorAll(StreamCommand(testContext(t), "printf 'a\\nb\\nc\\n'"))
+		assert.NotError(t, err)
+		assert.EqualItems(t, lines, []string{"a", "b", "c"})
+	})
+	t.Run("ExitError", func(t *testing.T) {
+		var lines []string
+		var errs []error
+		for line, err := range StreamCommand(testContext(t), "sh -c 'echo one; echo oops >&2; exit 2'") {
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			lines = append(lines, line)
+		}
+		assert.EqualItems(t, lines, []string{"one"})
+		assert.Equal(t, len(errs), 1)
+
+		var eo *ErrOutput
+		assert.True(t, errors.As(errs[0], &eo))
+		check.Equal(t, eo.ExitCode, 2)
+		check.Equal(t, eo.Err, "oops\n")
+	})
+	t.Run("EarlyReturn", func(t *testing.T) {
+		assert.MaxRuntime(t, 5*time.Second, func() {
+			count := 0
+			for line, err := range StreamCommand(testContext(t), "yes") {
+				assert.NotError(t, err)
+				check.Equal(t, line, "y")
+				count++
+				if count == 100 {
+					break
+				}
+			}
+			check.Equal(t, count, 100)
+		})
+	})
+	t.Run("Incremental", func(t *testing.T) {
+		start := time.Now()
+		for line := range StreamCommand(testContext(t), "sh -c 'echo first; sleep 1; echo second'") {
+			if line == "first" {
+				check.True(t, time.Since(start) < time.Second)
+			}
+		}
+	})
+}
+
+func TestCommand(t *testing.T) {
+	t.Run("ArgumentsWithSpaces", func(t *testing.T) {
+		out, err := MakeCommand("printf", "%s|", "one two", "three").Output(testContext(t))
+		assert.NotError(t, err)
+		assert.Equal(t, string(out), "one two|three|")
+	})
+	t.Run("Env", func(t *testing.T) {
+		cmd := MakeCommand("sh", "-c", "echo $LIBFUN_TEST_VAR")
+		cmd.Env = map[string]string{"LIBFUN_TEST_VAR": "value with spaces"}
+		seq, err := cmd.Lines(testContext(t))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"value with spaces"})
+	})
+	t.Run("Dir", func(t *testing.T) {
+		dir := t.TempDir()
+		cmd := MakeCommand("pwd")
+		cmd.Dir = dir
+		out, err := cmd.Output(testContext(t))
+		assert.NotError(t, err)
+		assert.Equal(t, strings.TrimSpace(string(out)), dir)
+	})
+	t.Run("HomeDir", func(t *testing.T) {
+		cmd := MakeCommand("pwd")
+		cmd.Dir = "~"
+		out, err := cmd.Output(testContext(t))
+		assert.NotError(t, err)
+		assert.Equal(t, strings.TrimSpace(string(out)), Homedir())
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		cmd := MakeCommand("sleep", "10")
+		cmd.Timeout = 50 * time.Millisecond
+		assert.MaxRuntime(t, 5*time.Second, func() {
+			err := cmd.Run(testContext(t))
+			assert.Error(t, err)
+			check.ErrorIs(t, err, context.DeadlineExceeded)
+
+			var eo *ErrOutput
+			check.True(t, errors.As(err, &eo))
+		})
+	})
+	t.Run("Stdin", func(t *testing.T) {
+		cmd := MakeCommand("cat")
+		cmd.Stdin = strings.NewReader("in\n")
+		seq, err := cmd.Lines(testContext(t))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"in"})
+	})
+	t.Run("Manager", func(t *testing.T) {
+		cmd := MakeCommand("true")
+		cmd.Manager = jasper.NewManager(jasper.ManagerOptionDefaults())
+		// no manager is attached to this context
+		assert.NotError(t, cmd.Run(t.Context()))
+	})
+	t.Run("ContextManager", func(t *testing.T) {
+		jpm := jasper.NewManager(jasper.ManagerOptionDefaults())
+		ctx := WithManager(testContext(t), jpm)
+		assert.NotError(t, MakeCommand("true").Run(ctx))
+
+		procs, err := jpm.List(ctx, options.All)
+		assert.NotError(t, err)
+		check.Equal(t, len(procs), 1)
+
+		procs, err = jasper.Context(ctx).List(ctx, options.All)
+		assert.NotError(t, err)
+		check.Equal(t, len(procs), 0)
+	})
+	t.Run("Result", func(t *testing.T) {
+		ctx := testContext(t)
+		cmd := MakeCommand("sh", "-c", "echo out; exit 2")
+		cmd.Tags = []string{"libfun-test"}
+
+		res := cmd.Result(ctx)
+		assert.Error(t, res.Err)
+		check.Equal(t, res.ExitCode, 2)
+		check.True(t, res.Process.ID != "")
+		check.True(t, res.Process.PID > 0)
+		check.True(t, res.Process.Complete)
+		check.EqualItems(t, res.Process.Options.Tags, []string{"libfun-test"})
+
+		procs, err := jasper.Context(ctx).Group(ctx, "libfun-test")
+		assert.NotError(t, err)
+		assert.Equal(t, len(procs), 1)
+		check.Equal(t, procs[0].ID(), res.Process.ID)
+
+		res = MakeCommand("echo", "ok").Result(ctx)
+		assert.NotError(t, res.Err)
+		check.Equal(t, string(res.Output), "ok\n")
+		check.True(t, res.Process.Successful)
+	})
+	t.Run("MaxProcs", func(t *testing.T) {
+		ctx := t.Context()
+		jpm := jasper.NewManager(jasper.ManagerOptionMaxProcs(1))
+
+		proc, err := jpm.CreateProcess(ctx, &options.Create{Args: []string{"sleep", "10"}})
+		assert.NotError(t, err)
+		defer func() { _ = proc.Signal(ctx, syscall.SIGKILL) }()
+
+		cmd := MakeCommand("true")
+		cmd.Manager = jpm
+		res := cmd.Result(ctx)
+		assert.Error(t, res.Err)
+		check.Equal(t, res.ExitCode, -1)
+		check.Equal(t, res.Process.ID, "")
+	})
+	t.Run("Stream", func(t *testing.T) {
+		lines, err := erc.FromIteratorAll(MakeCommand("seq", "3").Stream(testContext(t)))
+		assert.NotError(t, err)
+		assert.EqualItems(t, lines, []string{"1", "2", "3"})
+	})
+	t.Run("Empty", func(t *testing.T) {
+		check.ErrorIs(t, Command{}.Run(testContext(t)), ErrUndefinedOperation)
+		_, err := ParseCommand("   ")
+		check.ErrorIs(t, err, ErrUndefinedOperation)
+	})
+}
+
+func TestExitClassification(t *testing.T) {
+	t.Run("NonZeroExit", func(t *testing.T) {
+		err := MakeCommand("sh", "-c", "exit 3").Run(testContext(t))
+		check.ErrorIs(t, err, ErrNonZeroExit)
+		check.True(t, ers.Is(err, ErrNonZeroExit))
+		check.True(t, !errors.Is(err, ErrCommandNotFound))
+		eo := errOutput(t, err)
+		check.Equal(t, eo.ExitCode, 3)
+		check.Equal(t, eo.Signal, 0)
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		err := MakeCommand("libfun-command-does-not-exist").Run(testContext(t))
+		check.ErrorIs(t, err, ErrCommandNotFound)
+		check.ErrorIs(t, err, exec.ErrNotFound)
+
+		err = MakeCommand("/libfun/command/does/not/exist").Run(testContext(t))
+		check.ErrorIs(t, err, ErrCommandNotFound)
+	})
+	t.Run("Signal", func(t *testing.T) {
+		err := MakeCommand("sh", "-c", "kill -TERM $$").Run(testContext(t))
+		check.ErrorIs(t, err, ErrSignaled)
+		eo := errOutput(t, err)
+		check.Equal(t, eo.Signal, syscall.SIGTERM)
+		check.Equal(t, eo.ExitCode, -1)
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		cmd := MakeCommand("sleep", "10")
+		cmd.Timeout = 50 * time.Millisecond
+		err := cmd.Run(testContext(t))
+		check.ErrorIs(t, err, ErrCommandTimeout)
+		check.True(t, !errors.Is(err, ErrSignaled))
+	})
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testContext(t))
+		time.AfterFunc(50*time.Millisecond, cancel)
+		err := MakeCommand("sleep", "10").Run(ctx)
+		check.ErrorIs(t, err, ErrCommandCanceled)
+		check.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("Unclassified", func(t *testing.T) {
+		cmd := MakeCommand("true")
+		cmd.Dir = "/libfun/dir/does/not/exist"
+		err := cmd.Run(testContext(t))
+		assert.Error(t, err)
+		eo := errOutput(t, err)
+		check.True(t, eo.Kind == nil)
+	})
+}
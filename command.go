@@ -0,0 +1,326 @@
+package libfun
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/shlex"
+
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+	"github.com/tychoish/fun/irt"
+	"github.com/tychoish/grip/level"
+	"github.com/tychoish/jasper"
+)
+
+// Command describes a single process to run. Unlike the string
+// based helpers, the arguments are passed to the process as-is,
+// and are never split or interpreted by a shell. The zero value
+// for all fields except Args is valid. Fields that can also be
+// attached to the context (e.g. Executor and WithExecutor) take
+// precedence over the context.
+type Command struct {
+	// Args is the name of the program, followed by its
+	// arguments.
+	Args []string
+	// Env holds environment variables that are set in addition
+	// to the environment of the current process.
+	Env map[string]string
+	// Dir is the working directory of the process. Leading
+	// tildes are expanded to the user's home directory.
+	Dir string
+	// Timeout, when positive, terminates the process if it runs
+	// for longer than the duration.
+	Timeout time.Duration
+	// KillGrace is the time between sending SIGTERM and SIGKILL
+	// to the process group of a command that is terminated,
+	// because its context is canceled, its Timeout expires, or
+	// it exceeds its Limits. It defaults to DefaultKillGrace, and
+	// when negative, the process group is killed with SIGKILL
+	// immediately.
+	KillGrace time.Duration
+	// Stdin, when non-nil, is passed to the process' standard
+	// input.
+	Stdin io.Reader
+	// Input, when non-nil, takes precedence over Stdin, and is
+	// written to the process' standard input as it is produced
+	// (see InputLines). Iteration blocks while the process is not
+	// consuming its input, and stops if the process exits before
+	// consuming all of it. The sequence is iterated once for
+	// every time the command runs.
+	Input iter.Seq[[]byte]
+	// Manager is optional and defaults to the manager attached
+	// to the context with WithManager, or to the
+	// jasper.Manager attached to the context.
+	Manager jasper.Manager
+	// Tags are added to the process in the manager, so that
+	// processes can be found with the manager's Group method.
+	Tags []string
+	// Retry controls if and how failed commands are retried by
+	// Run, Lines, and Output. Stream never retries, as output
+	// may have already been consumed.
+	Retry RetryPolicy
+	// Cache, when specified, serves the output of Run, Lines,
+	// and Output from the cache, and stores the output of
+	// successful commands in the cache.
+	Cache *CommandCache
+	// Executor defaults to JasperExecutor.
+	Executor Executor
+	// Split, when specified, divides the output of Lines and
+	// Stream into records rather than lines (e.g. ScanNull for
+	// NUL terminated output).
+	Split bufio.SplitFunc
+	// LogUsage, when specified, logs the ResourceUsage of every
+	// execution of the command at this level, using the grip
+	// logger attached to the context.
+	LogUsage level.Priority
+	// Audit, when specified, records every execution of the
+	// command.
+	Audit *CommandAudit
+	// Limits, when specified, bound the output of the command,
+	// which is terminated when it exceeds a limit.
+	Limits OutputLimits
+	// Tee, when specified, receives a copy of the output of the
+	// command.
+	Tee *CommandTee
+	// Progress, when specified, reports the progress of the
+	// command while it runs.
+	Progress *CommandProgress
+}
+
+// DefaultKillGrace is the default KillGrace of commands.
+const DefaultKillGrace = 5 * time.Second
+
+func (c Command) killGrace() time.Duration {
+	switch {
+	case c.KillGrace == 0:
+		return DefaultKillGrace
+	case c.KillGrace < 0:
+		return 0
+	default:
+		return c.KillGrace
+	}
+}
+
+// MakeCommand constructs a Command from a program name and its
+// arguments.
+func MakeCommand(args ...string) Command { return Command{Args: args} }
+
+// ParseCommand splits a command string into arguments, using shell
+// quoting rules, and returns the equivalent Command. No shell is
+// involved in running the resulting command.
+func ParseCommand(cmd string) (Command, error) {
+	args, err := shlex.Split(cmd)
+	if err != nil {
+		return Command{}, ers.Wrapf(err, "parsing command %q", cmd)
+	}
+	if len(args) == 0 {
+		return Command{}, ers.Wrapf(ErrUndefinedOperation, "empty command %q", cmd)
+	}
+	return Command{Args: args}, nil
+}
+
+func (c Command) String() string { return strings.Join(c.Args, " ") }
+
+// Run executes the command and waits for it to complete. The output
+// of the command is only retained to report errors.
+func (c Command) Run(ctx context.Context) error { _, err := c.Output(ctx); return err }
+
+// Lines executes the command and returns an iterator over the lines
+// of its standard output, or over records when Split is specified.
+func (c Command) Lines(ctx context.Context) (iter.Seq[string], error) {
+	out, err := c.Output(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(out, c.Split)
+}
+
+// Output executes the command and returns its standard output. If
+// the command fails the error is an *ErrOutput that contains the
+// output of the command, or an *ErrAttempts when the command has a
+// retry policy.
+func (c Command) Output(ctx context.Context) ([]byte, error) {
+	if c.Cache != nil {
+		return c.Cache.Output(ctx, c)
+	}
+	return c.Retry.do(ctx, c.Stdin, func() ([]byte, error) { return c.output(ctx) })
+}
+
+// Result executes the command, as with Output, and reports its
+// output, exit code, and duration, along with the jasper process
+// information for the command. Errors, including those from the
+// manager refusing to create the process (e.g. because the manager
+// has reached MaxProcs), are reported in the result's Err field.
+func (c Command) Result(ctx context.Context) CommandResult {
+	return CommandResult{Command: c}.run(ctx)
+}
+
+func (c Command) output(ctx context.Context) ([]byte, error) {
+	var stdoutBuf bytes.Buffer
+	var stderrBuf bytes.Buffer
+
+	if err := c.exec(ctx, &stdoutBuf, &stderrBuf); err != nil {
+		return nil, newErrOutput(c.String(), err, stdoutBuf.String(), stderrBuf.String())
+	}
+
+	return stdoutBuf.Bytes(), nil
+}
+
+// Stream executes the command and yields lines from its standard
+// output as they are written, rather than after the command
+// exits. When the consumer stops iterating the process is
+// terminated. If the command fails, the final element of the
+// sequence is an *ErrOutput that contains the command's standard
+// error. Lines longer than 64 MiB are an error.
+func (c Command) Stream(ctx context.Context) iter.Seq2[string, error] {
+	return irt.Convert2(c.stream(ctx, c.Split), func(rec []byte, err error) (string, error) { return string(rec), err })
+}
+
+// Chunks is the same as Stream, but yields the command's standard
+// output in the chunks that the process writes, without splitting or
+// copying it into strings.
+func (c Command) Chunks(ctx context.Context) iter.Seq2[[]byte, error] {
+	return irt.Convert2(c.stream(ctx, ScanChunks), func(chunk []byte, err error) ([]byte, error) { return slices.Clone(chunk), err })
+}
+
+func (c Command) stream(ctx context.Context, split bufio.SplitFunc) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var stderrBuf bytes.Buffer
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+
+		go func() {
+			defer close(done)
+			err := c.exec(ctx, pw, &stderrBuf)
+			_ = pw.Close()
+			done <- err
+		}()
+
+		abort := func() { cancel(); _ = pr.Close(); <-done }
+
+		for rec, err := range scan(pr, split) {
+			if err != nil {
+				abort()
+				yield(nil, err)
+				return
+			}
+			if !yield(rec, nil) {
+				abort()
+				return
+			}
+		}
+
+		if err := <-done; err != nil {
+			yield(nil, newErrOutput(c.String(), err, "", stderrBuf.String()))
+		}
+	}
+}
+
+type managerCtxKey struct{}
+
+// WithManager attaches a jasper.Manager to the context, which all
+// commands run with the context use unless the Command specifies its
+// own Manager. Unlike jasper.WithManager, the manager is only used
+// by this package, which makes it possible to route commands to a
+// remote or restricted manager without affecting other users of
+// the context.
+func WithManager(ctx context.Context, jpm jasper.Manager) context.Context {
+	return context.WithValue(ctx, managerCtxKey{}, jpm)
+}
+
+func (c Command) manager(ctx context.Context) jasper.Manager {
+	if c.Manager != nil {
+		return c.Manager
+	}
+	if jpm, ok := ctx.Value(managerCtxKey{}).(jasper.Manager); ok {
+		return jpm
+	}
+	return jasper.Context(ctx)
+}
+
+func (c Command) executor(ctx context.Context) Executor {
+	if c.Executor != nil {
+		return c.Executor
+	}
+	if ex, ok := ctx.Value(executorCtxKey{}).(Executor); ok {
+		return ex
+	}
+	return JasperExecutor()
+}
+
+// exec runs the command with its executor, writing its output to
+// the provided writers, and blocks until the command exits.
+func (c Command) exec(ctx context.Context, stdout, stderr io.Writer) error {
+	if len(c.Args) == 0 {
+		return ers.Wrap(ErrUndefinedOperation, "command has no arguments")
+	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	if c.Input != nil {
+		stdin, stop, err := feedInput(c.Input)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		c.Stdin, c.Input = stdin, nil
+	}
+
+	ctx, report := withReport(ctx)
+	*report = execReport{}
+
+	if tee := c.tee(ctx); tee != nil {
+		var flush func()
+		stdout, stderr, flush = tee.wrap(c.redaction(ctx).redactCommand(c.Args), stdout, stderr)
+		defer flush()
+	}
+
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	stdout, stderr = c.Limits.wrap(abort, stdout, stderr)
+
+	stdoutSize := NewSizeReportingWriter(stdout)
+	stderrSize := NewSizeReportingWriter(stderr)
+	stdout, stderr = stdoutSize, stderrSize
+
+	start := time.Now()
+	if progress := c.progress(ctx); progress != nil {
+		var stop func()
+		stdout, stderr, stop = progress.monitor(ctx, abort, c, start, stdoutSize, stderrSize)
+		defer stop()
+	}
+
+	err := c.executor(ctx).Execute(ctx, c, stdout, stderr)
+	report.Usage.Wall = time.Since(start)
+	report.Usage.StdoutBytes = stdoutSize.Size()
+	report.Usage.StderrBytes = stderrSize.Size()
+
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, ErrOutputLimitExceeded), errors.Is(cause, ErrCommandStalled):
+		err = erc.Join(err, cause)
+	case err != nil && ctx.Err() != nil:
+		err = erc.Join(err, ctx.Err())
+	}
+
+	c.logUsage(ctx, report, err)
+	if audit := c.audit(ctx); audit != nil {
+		audit.record(ctx, c, report, err)
+	}
+
+	return err
+}
@@ -0,0 +1,79 @@
+package libfun
+
+import (
+	"context"
+	"io"
+	"maps"
+	"os/exec"
+	"slices"
+
+	"github.com/tychoish/jasper/executor"
+	"github.com/tychoish/jasper/options"
+)
+
+// Executor runs a command, writes its output to the writers, and
+// blocks until it exits. Errors for unsuccessful exits should
+// implement `ExitCode() int`, as *exec.ExitError does.
+type Executor interface {
+	Execute(ctx context.Context, c Command, stdout, stderr io.Writer) error
+}
+
+// ExecutorFunc is a function that implements Executor.
+type ExecutorFunc func(ctx context.Context, c Command, stdout, stderr io.Writer) error
+
+func (ef ExecutorFunc) Execute(ctx context.Context, c Command, stdout, stderr io.Writer) error {
+	return ef(ctx, c, stdout, stderr)
+}
+
+type executorCtxKey struct{}
+
+// WithExecutor attaches an Executor to the context, for all
+// commands (including RunCommand and Ripgrep) run with the context.
+func WithExecutor(ctx context.Context, ex Executor) context.Context {
+	return context.WithValue(ctx, executorCtxKey{}, ex)
+}
+
+// JasperExecutor returns the default Executor, which runs commands
+// as processes using the command's jasper.Manager.
+func JasperExecutor() Executor { return ExecutorFunc(jasperExecute) }
+
+func jasperExecute(ctx context.Context, c Command, stdout, stderr io.Writer) error {
+	opts := &options.Create{
+		Args:             slices.Clone(c.Args),
+		WorkingDirectory: TryExpandHomedir(c.Dir),
+		StandardInput:    c.Stdin,
+		Output:           options.Output{Output: stdout, Error: stderr},
+		Tags:             slices.Clone(c.Tags),
+	}
+	for _, key := range slices.Sorted(maps.Keys(c.Env)) {
+		opts.AddEnvVar(key, c.Env[key])
+	}
+
+	// constructing the process here provides access to its
+	// resource usage and process group.
+	var cmd *exec.Cmd
+	stop := func() {}
+	opts.ResolveExecutor = func(ctx context.Context, args []string) (executor.Executor, error) {
+		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
+		stop = terminateGroup(cmd, c.killGrace())
+		return executor.MakeLocal(cmd), nil
+	}
+	defer func() { stop() }()
+
+	proc, err := c.manager(ctx).CreateProcess(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	// wait for canceled processes to report their actual status.
+	_, err = proc.Wait(context.WithoutCancel(ctx))
+
+	if report, ok := ctx.Value(reportCtxKey{}).(*execReport); ok {
+		report.Process = proc.Info(context.WithoutCancel(ctx))
+		if cmd != nil {
+			report.Usage.setProcessState(cmd.ProcessState)
+		}
+	}
+
+	return err
+}
@@ -3,15 +3,13 @@ module github.com/tychoish/libfun
 go 1.24
 
 require (
+	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
 	github.com/tychoish/fun v0.14.2
 	github.com/tychoish/godmenu v0.2.0
 	github.com/tychoish/grip v0.4.4
 	github.com/tychoish/jasper v0.1.4-0.20260118211229-5e2702b5c77b
 )
 
-require (
-	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510 // indirect
-	github.com/google/uuid v1.6.0 // indirect
-)
+require github.com/google/uuid v1.6.0 // indirect
 
 // replace github.com/tychoish/fun => ../fun
@@ -0,0 +1,51 @@
+package libfun
+
+import (
+	"iter"
+	"os"
+
+	"github.com/tychoish/fun/irt"
+)
+
+// InputLines converts a sequence of strings into input for
+// Command.Input, where each string is a line terminated by a
+// newline.
+func InputLines(seq iter.Seq[string]) iter.Seq[[]byte] {
+	return irt.Convert(seq, func(line string) []byte { return append([]byte(line), '\n') })
+}
+
+// InputRecords is the same as InputLines, but terminates each string
+// with the delimiter, e.g. 0 for programs that accept NUL
+// terminated input, like `xargs -0` or `fzf --read0`.
+func InputRecords(seq iter.Seq[string], delim byte) iter.Seq[[]byte] {
+	return irt.Convert(seq, func(rec string) []byte { return append([]byte(rec), delim) })
+}
+
+// feedInput writes the sequence to the write end of an OS pipe from
+// a background goroutine, and returns the read end for use as the
+// standard input of a process. Using an OS pipe means that the
+// process reads directly from the pipe, so the producer blocks while
+// the process is not consuming input.
+//
+// The returned function closes the read end, which causes further
+// writes to fail, and so stops the producer the next time the
+// sequence yields, if the process exits without reading all of its
+// input. The write end is closed, delivering EOF to the process, when
+// the sequence is exhausted.
+func feedInput(seq iter.Seq[[]byte]) (*os.File, func(), error) {
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	go func() {
+		defer func() { _ = wr.Close() }()
+		for chunk := range seq {
+			if _, err := wr.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	return rd, func() { _ = rd.Close() }, nil
+}
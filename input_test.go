@@ -0,0 +1,93 @@
+package libfun
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tychoish/fun/adt"
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+)
+
+func TestInput(t *testing.T) {
+	t.Run("Lines", func(t *testing.T) {
+		seq, err := RunCommandWithLines(testContext(t), "sort", irt.Slice([]string{"b", "c", "a"}))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"a", "b", "c"})
+	})
+	t.Run("Records", func(t *testing.T) {
+		cmd := MakeCommand("xargs", "-0", "-n1", "echo")
+		cmd.Input = InputRecords(irt.Slice([]string{"a b", "c\nd"}), 0)
+		seq, err := cmd.Lines(testContext(t))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"a b", "c", "d"})
+	})
+	t.Run("Streaming", func(t *testing.T) {
+		// each line is only produced after the previous line has
+		// been read back from the process, which would deadlock if
+		// the input were collected before the process started.
+		next := make(chan struct{}, 1)
+		next <- struct{}{}
+		cmd := MakeCommand("cat")
+		cmd.Input = InputLines(func(yield func(string) bool) {
+			for i := range 3 {
+				select {
+				case <-next:
+				case <-time.After(5 * time.Second):
+					return
+				}
+				if !yield(strconv.Itoa(i)) {
+					return
+				}
+			}
+		})
+
+		var out []string
+		for line, err := range cmd.Stream(testContext(t)) {
+			assert.NotError(t, err)
+			out = append(out, line)
+			next <- struct{}{}
+		}
+		assert.EqualItems(t, out, []string{"0", "1", "2"})
+	})
+	t.Run("EarlyExit", func(t *testing.T) {
+		produced := &adt.AtomicInteger[int]{}
+		stopped := make(chan struct{})
+		cmd := MakeCommand("head", "-n", "3")
+		cmd.Input = InputLines(func(yield func(string) bool) {
+			defer close(stopped)
+			for i := 0; ; i++ {
+				produced.Add(1)
+				if !yield(strconv.Itoa(i)) {
+					return
+				}
+			}
+		})
+
+		seq, err := cmd.Lines(testContext(t))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"0", "1", "2"})
+
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("producer did not stop")
+		}
+		check.True(t, produced.Get() > 3)
+	})
+	t.Run("Cache", func(t *testing.T) {
+		cc := &CommandCache{Dir: t.TempDir()}
+		run := func(lines ...string) string {
+			cmd := MakeCommand("cat")
+			cmd.Input = InputLines(irt.Slice(lines))
+			out, err := cc.Output(testContext(t), cmd)
+			assert.NotError(t, err)
+			return string(out)
+		}
+		check.Equal(t, run("a"), "a\n")
+		check.Equal(t, run("b"), "b\n")
+		check.Equal(t, run("a"), "a\n")
+	})
+}
@@ -0,0 +1,136 @@
+package libfun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+)
+
+// ErrDecodeLine reports a line of a command's output that could not
+// be decoded. Line numbers start at 1.
+type ErrDecodeLine struct {
+	Line  int
+	Input string
+	Err   error
+}
+
+func (e *ErrDecodeLine) Error() string {
+	return fmt.Sprintf("decoding line %d %q: %v", e.Line, truncateOutput(e.Input), e.Err)
+}
+
+func (e *ErrDecodeLine) Unwrap() error { return e.Err }
+
+// RunCommandJSON runs the command, as with RunCommand, and decodes
+// its standard output as a single JSON document.
+func RunCommandJSON[T any](ctx context.Context, cmd string) (out T, err error) {
+	c, err := ParseCommand(cmd)
+	if err != nil {
+		return out, err
+	}
+
+	return CommandJSON[T](ctx, c)
+}
+
+// RunCommandJSONLines runs the command, as with StreamCommand, and
+// decodes each non-empty line of its standard output as a JSON
+// document. Lines that cannot be decoded produce an *ErrDecodeLine,
+// and iteration continues with the next line if the consumer
+// continues.
+func RunCommandJSONLines[T any](ctx context.Context, cmd string) iter.Seq2[T, error] {
+	c, err := ParseCommand(cmd)
+	if err != nil {
+		return func(yield func(T, error) bool) { var zero T; yield(zero, err) }
+	}
+
+	return CommandJSONLines[T](ctx, c)
+}
+
+// CommandJSON executes the command and decodes its standard output,
+// as it is produced, as a single JSON document.
+func CommandJSON[T any](ctx context.Context, c Command) (out T, err error) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var stderrBuf bytes.Buffer
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		defer close(done)
+		err := c.exec(ctx, pw, &stderrBuf)
+		_ = pw.Close()
+		done <- err
+	}()
+
+	dec := json.NewDecoder(pr)
+	if err = dec.Decode(&out); err != nil {
+		err = ers.Wrapf(err, "decoding output of %q at offset %d", c.String(), dec.InputOffset())
+		cancel()
+		_ = pr.Close()
+		// the process is terminated when the output cannot be
+		// decoded, which is only worth reporting if the process
+		// failed on its own.
+		if perr := <-done; perr != nil && (parent.Err() != nil || !errors.Is(perr, context.Canceled)) {
+			err = erc.Join(err, newErrOutput(c.String(), perr, "", stderrBuf.String()))
+		}
+		return out, err
+	}
+
+	// consume the rest of the output so the process can exit, and
+	// reject trailing data which would indicate that the output
+	// was not a single document.
+	rest, rerr := io.ReadAll(io.MultiReader(dec.Buffered(), pr))
+	if err := <-done; err != nil {
+		return out, newErrOutput(c.String(), err, string(rest), stderrBuf.String())
+	}
+	if rerr != nil {
+		return out, rerr
+	}
+	if trailing := strings.TrimSpace(string(rest)); trailing != "" {
+		return out, fmt.Errorf("decoding output of %q: unexpected data after document: %q", c.String(), truncateOutput(trailing))
+	}
+
+	return out, nil
+}
+
+// CommandJSONLines executes the command and decodes each non-empty
+// line of its standard output, as it is produced, as a JSON
+// document. Lines that cannot be decoded produce an *ErrDecodeLine,
+// and iteration continues with the next line if the consumer
+// continues.
+func CommandJSONLines[T any](ctx context.Context, c Command) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		num := 0
+		for line, err := range c.Stream(ctx) {
+			var out T
+			if err != nil {
+				if !yield(out, err) {
+					return
+				}
+				continue
+			}
+
+			num++
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			if err = json.Unmarshal([]byte(line), &out); err != nil {
+				err = &ErrDecodeLine{Line: num, Input: line, Err: err}
+			}
+
+			if !yield(out, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package libfun
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+)
+
+type jsonTestRecord struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+}
+
+func TestCommandJSON(t *testing.T) {
+	t.Run("Document", func(t *testing.T) {
+		out, err := RunCommandJSON[jsonTestRecord](testContext(t), `echo '{"name": "one", "value": 1}'`)
+		assert.NotError(t, err)
+		check.Equal(t, out, jsonTestRecord{Name: "one", Value: 1})
+	})
+	t.Run("MultilineDocument", func(t *testing.T) {
+		out, err := RunCommandJSON[[]int](testContext(t), `printf '[\n1,\n2,\n3\n]\n'`)
+		assert.NotError(t, err)
+		check.EqualItems(t, out, []int{1, 2, 3})
+	})
+	t.Run("InvalidDocument", func(t *testing.T) {
+		_, err := RunCommandJSON[jsonTestRecord](testContext(t), `echo '{"name": x}'`)
+		var se *json.SyntaxError
+		check.True(t, errors.As(err, &se))
+
+		// the process is terminated when decoding fails, which
+		// is not itself an error.
+		_, err = RunCommandJSON[jsonTestRecord](testContext(t), `sh -c 'echo "{\"name\": x}"; sleep 10'`)
+		check.True(t, errors.As(err, &se))
+		check.True(t, !errors.Is(err, ErrCommandCanceled))
+
+		_, err = RunCommandJSON[jsonTestRecord](testContext(t), `sh -c 'echo "{\"name\": "; exit 2'`)
+		check.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		check.ErrorIs(t, err, ErrNonZeroExit)
+	})
+	t.Run("TrailingData", func(t *testing.T) {
+		_, err := RunCommandJSON[jsonTestRecord](testContext(t), `printf '{"name": "a"}\n{"name": "b"}\n'`)
+		assert.Error(t, err)
+		check.Substring(t, err.Error(), "unexpected data")
+	})
+	t.Run("CommandFails", func(t *testing.T) {
+		_, err := RunCommandJSON[jsonTestRecord](testContext(t), `sh -c 'echo "{}"; exit 1'`)
+		var eo *ErrOutput
+		assert.True(t, errors.As(err, &eo))
+		check.Equal(t, eo.ExitCode, 1)
+	})
+	t.Run("Lines", func(t *testing.T) {
+		var out []jsonTestRecord
+		for rec, err := range RunCommandJSONLines[jsonTestRecord](testContext(t), `printf '{"name": "a", "value": 1}\n\n{"name": "b", "value": 2}\n'`) {
+			assert.NotError(t, err)
+			out = append(out, rec)
+		}
+		check.EqualItems(t, out, []jsonTestRecord{{Name: "a", Value: 1}, {Name: "b", Value: 2}})
+	})
+	t.Run("LongLines", func(t *testing.T) {
+		var out []jsonTestRecord
+		cmd := MakeCommand("sh", "-c", `printf '{"name": "'; head -c 70000 /dev/zero | tr '\0' a; printf '"}\n'`)
+		for rec, err := range CommandJSONLines[jsonTestRecord](testContext(t), cmd) {
+			assert.NotError(t, err)
+			out = append(out, rec)
+		}
+		assert.Equal(t, len(out), 1)
+		check.Equal(t, len(out[0].Name), 70000)
+	})
+	t.Run("LineErrors", func(t *testing.T) {
+		var (
+			out  []jsonTestRecord
+			errs []error
+		)
+		for rec, err := range RunCommandJSONLines[jsonTestRecord](testContext(t), `printf '{"name": "a"}\nnot json\n{"name": "c"}\n'`) {
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			out = append(out, rec)
+		}
+		check.Equal(t, len(out), 2)
+		assert.Equal(t, len(errs), 1)
+
+		var de *ErrDecodeLine
+		assert.True(t, errors.As(errs[0], &de))
+		check.Equal(t, de.Line, 2)
+		check.Equal(t, de.Input, "not json")
+	})
+	t.Run("LinesCommandFails", func(t *testing.T) {
+		var errs []error
+		for _, err := range RunCommandJSONLines[jsonTestRecord](testContext(t), `sh -c 'echo "{}"; exit 3'`) {
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		assert.Equal(t, len(errs), 1)
+		var eo *ErrOutput
+		check.True(t, errors.As(errs[0], &eo))
+	})
+}
@@ -0,0 +1,98 @@
+package libfun
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/tychoish/fun/ers"
+)
+
+// OutputLimits bound the output of a command, so that misbehaving
+// commands cannot exhaust memory. When the standard output or
+// standard error of a command exceeds a limit, the output is
+// truncated at the limit, the process is terminated, and the command
+// fails with an *ErrOutput whose Kind is ErrOutputLimitExceeded.
+// Limits that are zero or negative are not enforced.
+type OutputLimits struct {
+	StdoutBytes int
+	StdoutLines int
+	StderrBytes int
+	StderrLines int
+}
+
+func (ol OutputLimits) wrap(abort context.CancelCauseFunc, stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	if ol.StdoutBytes > 0 || ol.StdoutLines > 0 {
+		stdout = &limitWriter{name: "stdout", out: stdout, maxBytes: ol.StdoutBytes, maxLines: ol.StdoutLines, abort: abort}
+	}
+	if ol.StderrBytes > 0 || ol.StderrLines > 0 {
+		stderr = &limitWriter{name: "stderr", out: stderr, maxBytes: ol.StderrBytes, maxLines: ol.StderrLines, abort: abort}
+	}
+	return stdout, stderr
+}
+
+// limitWriter passes output to the underlying writer until it
+// exceeds a limit, and then discards all further output and aborts
+// the command. Discarding, rather than returning an error, avoids
+// blocking or breaking the process' pipes before it terminates.
+type limitWriter struct {
+	name     string
+	out      io.Writer
+	maxBytes int
+	maxLines int
+	abort    context.CancelCauseFunc
+
+	mtx      sync.Mutex
+	bytes    int
+	lines    int
+	exceeded bool
+}
+
+func (lw *limitWriter) Write(in []byte) (int, error) {
+	lw.mtx.Lock()
+	defer lw.mtx.Unlock()
+
+	if lw.exceeded {
+		return len(in), nil
+	}
+
+	allowed := len(in)
+	var err error
+	if lw.maxBytes > 0 && lw.bytes+allowed > lw.maxBytes {
+		allowed = lw.maxBytes - lw.bytes
+		err = ers.Wrapf(ErrOutputLimitExceeded, "%s exceeded %d bytes", lw.name, lw.maxBytes)
+	}
+	if lw.maxLines > 0 {
+		// output is allowed up to, and including, the newline
+		// that ends the last permitted line.
+		for idx := 0; idx < allowed; idx++ {
+			if lw.lines == lw.maxLines {
+				allowed = idx
+				err = ers.Wrapf(ErrOutputLimitExceeded, "%s exceeded %d lines", lw.name, lw.maxLines)
+				break
+			}
+			next := bytes.IndexByte(in[idx:allowed], '\n')
+			if next < 0 {
+				break
+			}
+			idx += next
+			lw.lines++
+		}
+	}
+
+	if allowed > 0 {
+		n, werr := lw.out.Write(in[:allowed])
+		lw.bytes += n
+		if werr != nil {
+			return n, werr
+		}
+	}
+
+	if err != nil {
+		lw.exceeded = true
+		lw.abort(err)
+	}
+
+	return len(in), nil
+}
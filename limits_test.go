@@ -0,0 +1,96 @@
+package libfun
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+)
+
+func TestOutputLimits(t *testing.T) {
+	t.Run("StdoutBytes", func(t *testing.T) {
+		cmd := MakeCommand("sh", "-c", "printf 0123456789; exec sleep 60")
+		cmd.Limits = OutputLimits{StdoutBytes: 4}
+
+		start := time.Now()
+		_, err := cmd.Output(testContext(t))
+		check.True(t, time.Since(start) < 30*time.Second)
+
+		eo := errOutput(t, err)
+		check.Equal(t, eo.Kind, error(ErrOutputLimitExceeded))
+		check.Equal(t, eo.Out, "0123")
+		check.Substring(t, err.Error(), "stdout exceeded 4 bytes")
+	})
+	t.Run("StdoutLines", func(t *testing.T) {
+		cmd := MakeCommand("yes")
+		cmd.Limits = OutputLimits{StdoutLines: 3}
+
+		eo := errOutput(t, cmd.Run(testContext(t)))
+		check.Equal(t, eo.Kind, error(ErrOutputLimitExceeded))
+		check.Equal(t, eo.Out, "y\ny\ny\n")
+		check.Substring(t, eo.Error(), "stdout exceeded 3 lines")
+	})
+	t.Run("Stderr", func(t *testing.T) {
+		cmd := MakeCommand("sh", "-c", "echo ok; while true; do echo noise >&2; done")
+		cmd.Limits = OutputLimits{StderrBytes: 1024, StdoutBytes: 1024}
+
+		eo := errOutput(t, cmd.Run(testContext(t)))
+		check.Equal(t, eo.Kind, error(ErrOutputLimitExceeded))
+		check.Equal(t, eo.Out, "ok\n")
+		check.Equal(t, len(eo.Err), 1024)
+		check.Substring(t, eo.Error(), "stderr exceeded 1024 bytes")
+	})
+	t.Run("WithinLimits", func(t *testing.T) {
+		cmd := MakeCommand("printf", "a\nb\n")
+		cmd.Limits = OutputLimits{StdoutBytes: 4, StdoutLines: 2, StderrBytes: 1, StderrLines: 1}
+
+		out, err := cmd.Lines(testContext(t))
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(out), []string{"a", "b"})
+	})
+	t.Run("Stream", func(t *testing.T) {
+		cmd := MakeCommand("yes")
+		cmd.Limits = OutputLimits{StdoutLines: 100}
+
+		count := 0
+		var err error
+		for line, lerr := range cmd.Stream(testContext(t)) {
+			if lerr != nil {
+				err = lerr
+				break
+			}
+			check.Equal(t, line, "y")
+			count++
+		}
+		check.Equal(t, count, 100)
+		check.Equal(t, errOutput(t, err).Kind, error(ErrOutputLimitExceeded))
+	})
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testContext(t))
+		cancel()
+
+		cmd := MakeCommand("yes")
+		cmd.Limits = OutputLimits{StdoutLines: 1 << 30}
+		err := cmd.Run(ctx)
+		check.ErrorIs(t, err, ErrCommandCanceled)
+		check.True(t, !errors.Is(err, ErrOutputLimitExceeded))
+	})
+	t.Run("Writer", func(t *testing.T) {
+		var cause error
+		buf := &strings.Builder{}
+		lw := &limitWriter{name: "out", out: buf, maxLines: 2, abort: func(err error) { cause = err }}
+
+		for _, chunk := range []string{"one\ntw", "o\nthree\n", "four\n"} {
+			n, err := lw.Write([]byte(chunk))
+			check.NotError(t, err)
+			check.Equal(t, n, len(chunk))
+		}
+		check.Equal(t, buf.String(), "one\ntwo\n")
+		check.ErrorIs(t, cause, ErrOutputLimitExceeded)
+	})
+}
@@ -0,0 +1,162 @@
+package libfun
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/tychoish/fun/irt"
+	"github.com/tychoish/jasper"
+)
+
+// CommandResult reports the outcome of a command run by RunCommands.
+type CommandResult struct {
+	// Index is the position of the command in the input sequence.
+	Index   int
+	Command Command
+	// Output holds the standard output of successful commands.
+	Output []byte
+	// ExitCode is the exit code of the process, or -1 when the
+	// process did not exit normally or could not be started.
+	ExitCode int
+	Duration time.Duration
+	// Process describes the process in the jasper.Manager, and
+	// includes its ID, PID, and tags. Process is the zero value
+	// when the command did not run as a jasper process, as when
+	// the output came from a cache or from an alternate
+	// Executor. With retries, Process and Usage describe the
+	// last attempt.
+	Process jasper.ProcessInfo
+	Usage   ResourceUsage
+	// Err is nil for successful commands, and otherwise is
+	// typically an *ErrOutput.
+	Err error
+}
+
+// Lines returns an iterator over the lines of the command's standard
+// output, or over records when the command specifies Split.
+func (r CommandResult) Lines() (iter.Seq[string], error) {
+	return scanStrings(r.Output, r.Command.Split)
+}
+
+// RunCommands runs the commands with at most parallelism commands
+// running at once, and yields a result for each command as it
+// completes. Commands that do not specify a manager use jpm, or the
+// manager attached to the context if jpm is nil. When parallelism is
+// not positive, it defaults to the number of CPUs.
+//
+// When the consumer stops iterating, running commands are terminated
+// and no further commands are started.
+func RunCommands(ctx context.Context, jpm jasper.Manager, cmds iter.Seq[Command], parallelism int) iter.Seq[CommandResult] {
+	return runCommands(ctx, jpm, cmds, parallelism, false)
+}
+
+// RunCommandsOrdered is the same as RunCommands, except that results
+// are yielded in the same order as the input commands.
+func RunCommandsOrdered(ctx context.Context, jpm jasper.Manager, cmds iter.Seq[Command], parallelism int) iter.Seq[CommandResult] {
+	return runCommands(ctx, jpm, cmds, parallelism, true)
+}
+
+func runCommands(ctx context.Context, jpm jasper.Manager, cmds iter.Seq[Command], parallelism int, ordered bool) iter.Seq[CommandResult] {
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+
+	return func(yield func(CommandResult) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		jobs := make(chan CommandResult)
+		results := make(chan CommandResult)
+
+		go func() {
+			defer close(jobs)
+			for idx, cmd := range irt.Index(cmds) {
+				if cmd.Manager == nil {
+					cmd.Manager = jpm
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case jobs <- CommandResult{Index: idx, Command: cmd}:
+				}
+			}
+		}()
+
+		wg := &sync.WaitGroup{}
+		for range parallelism {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for job := range jobs {
+					select {
+					case <-ctx.Done():
+						return
+					case results <- job.run(ctx):
+					}
+				}
+			}()
+		}
+		go func() { wg.Wait(); close(results) }()
+
+		// ensure that all workers have returned before returning.
+		defer func() {
+			for range results {
+				continue
+			}
+		}()
+		defer cancel()
+
+		next := 0
+		pending := map[int]CommandResult{}
+
+		for res := range results {
+			if !ordered {
+				if !yield(res) {
+					return
+				}
+				continue
+			}
+
+			pending[res.Index] = res
+			for {
+				res, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+
+				if !yield(res) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r CommandResult) run(ctx context.Context) CommandResult {
+	ctx, report := withReport(ctx)
+
+	start := time.Now()
+	r.Output, r.Err = r.Command.Output(ctx)
+	r.Duration = time.Since(start)
+	r.Process = report.Process
+	r.Usage = report.Usage
+
+	var eo *ErrOutput
+	switch {
+	case r.Err == nil:
+		r.ExitCode = 0
+	case errors.As(r.Err, &eo):
+		r.ExitCode = eo.ExitCode
+	default:
+		r.ExitCode = -1
+	}
+
+	return r
+}
@@ -0,0 +1,94 @@
+package libfun
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+	"github.com/tychoish/jasper"
+)
+
+func TestRunCommands(t *testing.T) {
+	sleepers := func(durs ...string) []Command {
+		out := make([]Command, 0, len(durs))
+		for _, dur := range durs {
+			out = append(out, MakeCommand("sh", "-c", fmt.Sprintf("sleep %s; echo %s", dur, dur)))
+		}
+		return out
+	}
+
+	t.Run("Parallel", func(t *testing.T) {
+		cmds := sleepers("0.2", "0.2", "0.2", "0.2")
+		assert.MaxRuntime(t, 600*time.Millisecond, func() {
+			count := 0
+			for res := range RunCommands(testContext(t), nil, irt.Slice(cmds), 4) {
+				check.NotError(t, res.Err)
+				check.Equal(t, res.ExitCode, 0)
+				check.True(t, res.Duration >= 200*time.Millisecond)
+				lines, err := res.Lines()
+				check.NotError(t, err)
+				check.EqualItems(t, irt.Collect(lines), []string{"0.2"})
+				count++
+			}
+			check.Equal(t, count, 4)
+		})
+	})
+	t.Run("Bounded", func(t *testing.T) {
+		cmds := sleepers("0.1", "0.1", "0.1", "0.1")
+		assert.MinRuntime(t, 200*time.Millisecond, func() {
+			check.Equal(t, irt.Count(RunCommands(testContext(t), nil, irt.Slice(cmds), 2)), 4)
+		})
+	})
+	t.Run("Ordered", func(t *testing.T) {
+		durs := []string{"0.3", "0.0", "0.2", "0.1"}
+		var indexes []int
+		for res := range RunCommandsOrdered(testContext(t), nil, irt.Slice(sleepers(durs...)), 4) {
+			indexes = append(indexes, res.Index)
+			lines, err := res.Lines()
+			check.NotError(t, err)
+			check.EqualItems(t, irt.Collect(lines), []string{durs[res.Index]})
+		}
+		check.EqualItems(t, indexes, []int{0, 1, 2, 3})
+	})
+	t.Run("Unordered", func(t *testing.T) {
+		cmds := sleepers("0.3", "0.0")
+		var indexes []int
+		for res := range RunCommands(testContext(t), nil, irt.Slice(cmds), 2) {
+			indexes = append(indexes, res.Index)
+		}
+		check.EqualItems(t, indexes, []int{1, 0})
+	})
+	t.Run("Failures", func(t *testing.T) {
+		cmds := []Command{MakeCommand("true"), MakeCommand("sh", "-c", "echo bad >&2; exit 9"), MakeCommand("libfun-command-does-not-exist")}
+		results := irt.Collect(RunCommandsOrdered(testContext(t), nil, irt.Slice(cmds), 2))
+		assert.Equal(t, len(results), 3)
+		check.NotError(t, results[0].Err)
+		check.Equal(t, results[1].ExitCode, 9)
+
+		var eo *ErrOutput
+		assert.True(t, errors.As(results[1].Err, &eo))
+		check.Equal(t, eo.Err, "bad\n")
+		check.Error(t, results[2].Err)
+		check.Equal(t, results[2].ExitCode, -1)
+	})
+	t.Run("Manager", func(t *testing.T) {
+		jpm := jasper.NewManager(jasper.ManagerOptionDefaults())
+		// no manager is attached to this context
+		for res := range RunCommands(t.Context(), jpm, irt.Args(MakeCommand("true")), 1) {
+			check.NotError(t, res.Err)
+		}
+	})
+	t.Run("EarlyReturn", func(t *testing.T) {
+		cmds := append(sleepers("0.0"), MakeCommand("sleep", "10"), MakeCommand("sleep", "10"))
+		assert.MaxRuntime(t, 5*time.Second, func() {
+			for res := range RunCommandsOrdered(testContext(t), nil, irt.Slice(cmds), 4) {
+				check.Equal(t, res.Index, 0)
+				break
+			}
+		})
+	})
+}
@@ -0,0 +1,118 @@
+package libfun
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"iter"
+	"os"
+	"sync"
+
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+	"github.com/tychoish/jasper"
+)
+
+// Pipeline describes a sequence of commands, where the standard
+// output of each stage is connected to the standard input of the
+// next stage with an OS pipe, like "cmd1 | cmd2 | cmd3" in a shell,
+// but without a shell.
+type Pipeline struct {
+	// Stages are the commands in the pipeline, in order, which
+	// are split into arguments as with ParseCommand.
+	Stages []string
+	// Input is optional, and when specified, is passed to the
+	// standard input of the first stage.
+	Input io.Reader
+	// Manager is optional and defaults to the jasper.Manager
+	// attached to the context.
+	Manager jasper.Manager
+}
+
+// NewPipeline constructs a pipeline from the provided commands.
+func NewPipeline(stages ...string) *Pipeline { return &Pipeline{Stages: stages} }
+
+// Run starts all stages of the pipeline concurrently, waits for them
+// to complete, and returns an iterator over the lines of the final
+// stage's standard output.
+//
+// Like a shell with pipefail set, Run returns an error if any stage
+// fails. Each failing stage is reported as an *ErrOutput containing
+// that stage's standard error; errors.As resolves the right-most
+// failing stage.
+func (p *Pipeline) Run(ctx context.Context) (iter.Seq[string], error) {
+	if len(p.Stages) == 0 {
+		return nil, ers.Wrap(ErrUndefinedOperation, "pipeline has no stages")
+	}
+
+	cmds := make([]Command, 0, len(p.Stages))
+	for _, stage := range p.Stages {
+		cmd, err := ParseCommand(stage)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Manager = p.Manager
+		cmds = append(cmds, cmd)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		stdoutBuf bytes.Buffer
+		stderrs   = make([]bytes.Buffer, len(p.Stages))
+		errs      = make([]error, len(p.Stages))
+		stdin     = p.Input
+		last      = len(p.Stages) - 1
+	)
+
+	for idx, stage := range cmds {
+		stage.Stdin = stdin
+
+		var stdout io.Writer = &stdoutBuf
+		if idx != last {
+			rd, wr, err := os.Pipe()
+			if err != nil {
+				errs[idx] = err
+				closePipe(stdin, idx)
+				break
+			}
+			stdout, stdin = wr, rd
+		}
+
+		wg.Add(1)
+		go func(idx int, stage Command, stdout io.Writer) {
+			defer wg.Done()
+			errs[idx] = stage.exec(ctx, stdout, &stderrs[idx])
+			// closing the write end delivers EOF to the next
+			// stage, and closing the read end ensures that
+			// earlier stages observe a closed pipe if this
+			// stage exits before consuming all of its input.
+			closePipe(stdout, last-idx)
+			closePipe(stage.Stdin, idx)
+		}(idx, stage, stdout)
+	}
+
+	wg.Wait()
+
+	ec := &erc.Collector{}
+	for idx, err := range errs {
+		var stdout string
+		if idx == last {
+			stdout = stdoutBuf.String()
+		}
+		ec.Push(newErrOutput(p.Stages[idx], err, stdout, stderrs[idx].String()))
+	}
+	if !ec.Ok() {
+		return nil, ec.Resolve()
+	}
+
+	return scanStrings(stdoutBuf.Bytes(), nil)
+}
+
+// closePipe closes the ends of the pipes that connect stages. The
+// input of the first stage and the output of the last stage (where
+// the distance is zero) belong to the caller and are never closed.
+func closePipe(pipe any, distance int) {
+	if f, ok := pipe.(*os.File); ok && distance > 0 {
+		_ = f.Close()
+	}
+}
@@ -0,0 +1,61 @@
+package libfun
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+)
+
+func TestPipeline(t *testing.T) {
+	t.Run("Stages", func(t *testing.T) {
+		seq, err := NewPipeline("printf 'b\\na\\nc\\na\\n'", "sort", "uniq").Run(testContext(t))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"a", "b", "c"})
+	})
+	t.Run("Input", func(t *testing.T) {
+		pipe := NewPipeline("sort -r", "head -n 2")
+		pipe.Input = strings.NewReader("1\n2\n3\n")
+		seq, err := pipe.Run(testContext(t))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"3", "2"})
+	})
+	t.Run("SingleStage", func(t *testing.T) {
+		seq, err := NewPipeline("echo hi").Run(testContext(t))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"hi"})
+	})
+	t.Run("Empty", func(t *testing.T) {
+		_, err := NewPipeline().Run(testContext(t))
+		assert.ErrorIs(t, err, ErrUndefinedOperation)
+	})
+	t.Run("ParseError", func(t *testing.T) {
+		_, err := NewPipeline("echo hi", "echo 'unterminated").Run(testContext(t))
+		assert.Error(t, err)
+	})
+	t.Run("PipeFail", func(t *testing.T) {
+		seq, err := NewPipeline("sh -c 'echo first >&2; exit 4'", "cat", "sh -c 'cat; echo last >&2; exit 5'").Run(testContext(t))
+		assert.Error(t, err)
+		assert.True(t, seq == nil)
+
+		var eo *ErrOutput
+		assert.True(t, errors.As(err, &eo))
+		check.Equal(t, eo.ExitCode, 5)
+		check.Equal(t, eo.Err, "last\n")
+		check.Substring(t, err.Error(), "first")
+	})
+	t.Run("MiddleStageFails", func(t *testing.T) {
+		_, err := NewPipeline("echo hi", "sh -c 'cat >/dev/null; echo broken >&2; exit 7'", "cat").Run(testContext(t))
+		var eo *ErrOutput
+		assert.True(t, errors.As(err, &eo))
+		check.Equal(t, eo.ExitCode, 7)
+		check.Equal(t, eo.Err, "broken\n")
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := NewPipeline("echo hi", "libfun-command-does-not-exist", "cat").Run(testContext(t))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,235 @@
+package libfun
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tychoish/fun/ers"
+	"github.com/tychoish/grip"
+	"github.com/tychoish/grip/message"
+)
+
+// Progress is a snapshot of the progress of a running command.
+type Progress struct {
+	Cmd         string
+	Elapsed     time.Duration
+	StdoutBytes int
+	StderrBytes int
+	StdoutLines int
+	StderrLines int
+	// LastLine is the most recent non-empty line on either
+	// stream. Carriage returns (as in progress bars) end lines.
+	LastLine string
+	// Idle is the time since the command last wrote output.
+	Idle    time.Duration
+	Stalled bool
+	// Done is true for the final report, after the command exits.
+	Done bool
+}
+
+// CommandProgress reports the progress of commands while they run,
+// and detects commands that stall. Callbacks are called from a
+// separate goroutine, never concurrently, and should return promptly.
+type CommandProgress struct {
+	// Interval is the time between calls to Report, and defaults
+	// to one second.
+	Interval time.Duration
+	// Report, when specified, is called every Interval while the
+	// command runs, and once more, with Done set, after it exits.
+	Report func(Progress)
+	// StallAfter, when positive, is the time that the command
+	// can run without writing output before it stalls, and
+	// OnStall is called (or, by default, a warning is logged).
+	StallAfter time.Duration
+	OnStall    func(Progress)
+	// CancelOnStall terminates commands that stall, which then
+	// fail with an *ErrOutput whose Kind is ErrCommandStalled.
+	CancelOnStall bool
+}
+
+type progressCtxKey struct{}
+
+// WithProgress attaches a CommandProgress to the context, for all
+// commands (including RunCommand and Ripgrep) run with the context.
+func WithProgress(ctx context.Context, progress *CommandProgress) context.Context {
+	return context.WithValue(ctx, progressCtxKey{}, progress)
+}
+
+func (c Command) progress(ctx context.Context) *CommandProgress {
+	if c.Progress != nil {
+		return c.Progress
+	}
+	if progress, ok := ctx.Value(progressCtxKey{}).(*CommandProgress); ok {
+		return progress
+	}
+	return nil
+}
+
+// monitor returns writers that track the output of the command, and
+// a function that stops monitoring and sends the final report.
+func (cp *CommandProgress) monitor(
+	ctx context.Context,
+	abort context.CancelCauseFunc,
+	c Command,
+	start time.Time,
+	stdout, stderr *SizeReportingWriter,
+) (io.Writer, io.Writer, func()) {
+	pm := &progressMonitor{
+		cmd:     c.String(),
+		start:   start,
+		last:    start,
+		outSize: stdout,
+		errSize: stderr,
+	}
+	outTracker := &progressTracker{pm: pm, out: stdout, lines: &pm.outLines}
+	errTracker := &progressTracker{pm: pm, out: stderr, lines: &pm.errLines}
+
+	done := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// logs are redacted in the same way as audit records.
+		cp.watch(ctx, abort, pm, c.redaction(ctx).redactCommand(c.Args), done)
+	}()
+
+	return outTracker, errTracker, func() {
+		close(done)
+		wg.Wait()
+		if cp.Report != nil {
+			p := pm.snapshot(cp.StallAfter)
+			p.Done = true
+			cp.Report(p)
+		}
+	}
+}
+
+func (cp *CommandProgress) watch(ctx context.Context, abort context.CancelCauseFunc, pm *progressMonitor, cmd string, done <-chan struct{}) {
+	var reports, stalls <-chan time.Time
+
+	if cp.Report != nil {
+		interval := cp.Interval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		reports = ticker.C
+	}
+
+	if cp.StallAfter > 0 {
+		ticker := time.NewTicker(max(cp.StallAfter/4, time.Millisecond))
+		defer ticker.Stop()
+		stalls = ticker.C
+	}
+
+	stalled := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-reports:
+			cp.Report(pm.snapshot(cp.StallAfter))
+		case <-stalls:
+			p := pm.snapshot(cp.StallAfter)
+			if !p.Stalled {
+				stalled = false
+				continue
+			}
+			if stalled {
+				continue
+			}
+			stalled = true
+
+			if cp.OnStall != nil {
+				cp.OnStall(p)
+			} else {
+				grip.Context(ctx).Warning(message.Fields{
+					"message": "command stalled",
+					"cmd":     cmd,
+					"idle":    p.Idle,
+					"elapsed": p.Elapsed,
+					"cancel":  cp.CancelOnStall,
+				})
+			}
+
+			if cp.CancelOnStall {
+				abort(ers.Wrapf(ErrCommandStalled, "no output for %s", p.Idle.Round(time.Millisecond)))
+			}
+		}
+	}
+}
+
+// progressMonitor holds the state of the output of a command, which
+// the trackers for both streams update.
+type progressMonitor struct {
+	cmd     string
+	start   time.Time
+	outSize *SizeReportingWriter
+	errSize *SizeReportingWriter
+
+	mtx      sync.Mutex
+	last     time.Time
+	lastLine string
+	outLines int
+	errLines int
+}
+
+func (pm *progressMonitor) snapshot(stallAfter time.Duration) Progress {
+	pm.mtx.Lock()
+	defer pm.mtx.Unlock()
+
+	now := time.Now()
+	p := Progress{
+		Cmd:         pm.cmd,
+		Elapsed:     now.Sub(pm.start),
+		StdoutBytes: pm.outSize.Size(),
+		StderrBytes: pm.errSize.Size(),
+		StdoutLines: pm.outLines,
+		StderrLines: pm.errLines,
+		LastLine:    pm.lastLine,
+		Idle:        now.Sub(pm.last),
+	}
+	p.Stalled = stallAfter > 0 && p.Idle >= stallAfter
+	return p
+}
+
+// progressTracker counts the lines of one stream, and records the
+// last line and the time of the last write.
+type progressTracker struct {
+	pm      *progressMonitor
+	out     io.Writer
+	lines   *int
+	partial []byte
+}
+
+func (pt *progressTracker) Write(in []byte) (int, error) {
+	n, err := pt.out.Write(in)
+
+	pt.pm.mtx.Lock()
+	defer pt.pm.mtx.Unlock()
+
+	pt.pm.last = time.Now()
+	*pt.lines += bytes.Count(in[:n], []byte{'\n'})
+
+	pt.partial = append(pt.partial, in[:n]...)
+	if idx := bytes.LastIndexAny(pt.partial, "\r\n"); idx >= 0 {
+		complete := bytes.TrimRight(pt.partial[:idx], "\r\n")
+		if start := bytes.LastIndexAny(complete, "\r\n"); start >= 0 {
+			complete = complete[start+1:]
+		}
+		if len(complete) > 0 {
+			pt.pm.lastLine = string(complete)
+		}
+		pt.partial = append(pt.partial[:0], pt.partial[idx+1:]...)
+	}
+	// bound the size of lines that never end.
+	if over := len(pt.partial) - MaxErrOutputSize; over > 0 {
+		pt.partial = append(pt.partial[:0], pt.partial[over:]...)
+	}
+
+	return n, err
+}
@@ -0,0 +1,125 @@
+package libfun
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/grip"
+	"github.com/tychoish/grip/level"
+	"github.com/tychoish/grip/send"
+)
+
+type progressReports struct {
+	mtx     sync.Mutex
+	reports []Progress
+}
+
+func (pr *progressReports) add(p Progress) {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+	pr.reports = append(pr.reports, p)
+}
+
+func (pr *progressReports) all() []Progress {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+	return append([]Progress(nil), pr.reports...)
+}
+
+func TestCommandProgress(t *testing.T) {
+	t.Run("Report", func(t *testing.T) {
+		reports := &progressReports{}
+		cmd := MakeCommand("sh", "-c", "echo one; echo err >&2; sleep 0.3; printf 'two\\nthree\\n'")
+		cmd.Progress = &CommandProgress{Interval: 50 * time.Millisecond, Report: reports.add}
+
+		assert.NotError(t, cmd.Run(testContext(t)))
+
+		all := reports.all()
+		assert.True(t, len(all) >= 3)
+		for _, p := range all[:len(all)-1] {
+			check.True(t, !p.Done)
+		}
+		// reports during the sleep see the first lines.
+		check.True(t, all[1].StdoutLines >= 1)
+		check.True(t, all[1].StdoutBytes >= 4)
+
+		final := all[len(all)-1]
+		check.True(t, final.Done)
+		check.Equal(t, final.Cmd, cmd.String())
+		check.Equal(t, final.StdoutLines, 3)
+		check.Equal(t, final.StdoutBytes, 14)
+		check.Equal(t, final.StderrLines, 1)
+		check.Equal(t, final.StderrBytes, 4)
+		check.Equal(t, final.LastLine, "three")
+		check.True(t, final.Elapsed >= 300*time.Millisecond)
+		check.True(t, !final.Stalled)
+	})
+	t.Run("CarriageReturn", func(t *testing.T) {
+		reports := &progressReports{}
+		cmd := MakeCommand("printf", "10%%\r50%%\r100%%\rpartial")
+		cmd.Progress = &CommandProgress{Report: reports.add}
+		assert.NotError(t, cmd.Run(testContext(t)))
+
+		all := reports.all()
+		assert.Equal(t, len(all), 1)
+		check.Equal(t, all[0].LastLine, "100%")
+		check.Equal(t, all[0].StdoutLines, 0)
+	})
+	t.Run("StallWarning", func(t *testing.T) {
+		sender := send.MakeInternal()
+		ctx := grip.WithLogger(testContext(t), grip.NewLogger(sender))
+
+		cmd := MakeCommand("sh", "-c", "echo start; sleep 0.4; echo end", "--token=hunter2")
+		cmd.Progress = &CommandProgress{StallAfter: 100 * time.Millisecond}
+		assert.NotError(t, cmd.Run(ctx))
+
+		assert.Equal(t, sender.Len(), 1)
+		msg := sender.GetMessage()
+		check.Equal(t, msg.Priority, level.Warning)
+		check.Substring(t, msg.Rendered, "command stalled")
+		check.Substring(t, msg.Rendered, "--token="+Redacted)
+		check.NotSubstring(t, msg.Rendered, "hunter2")
+	})
+	t.Run("OnStall", func(t *testing.T) {
+		stalls := &progressReports{}
+		cmd := MakeCommand("sh", "-c", "sleep 0.3; echo middle; sleep 0.3")
+		cmd.Progress = &CommandProgress{StallAfter: 100 * time.Millisecond, OnStall: stalls.add}
+		assert.NotError(t, cmd.Run(testContext(t)))
+
+		// output resets the detector, so the command stalls
+		// twice.
+		all := stalls.all()
+		assert.Equal(t, len(all), 2)
+		check.True(t, all[0].Stalled)
+		check.True(t, all[0].Idle >= 100*time.Millisecond)
+		check.Equal(t, all[1].LastLine, "middle")
+	})
+	t.Run("CancelOnStall", func(t *testing.T) {
+		cmd := MakeCommand("sh", "-c", "echo waiting; exec sleep 60")
+		cmd.Progress = &CommandProgress{StallAfter: 100 * time.Millisecond, OnStall: func(Progress) {}, CancelOnStall: true}
+
+		start := time.Now()
+		_, err := cmd.Output(testContext(t))
+		check.True(t, time.Since(start) < 30*time.Second)
+		check.ErrorIs(t, err, ErrCommandStalled)
+
+		var eo *ErrOutput
+		assert.True(t, errors.As(err, &eo))
+		check.Equal(t, eo.Kind, error(ErrCommandStalled))
+		check.Equal(t, eo.Out, "waiting\n")
+	})
+	t.Run("Context", func(t *testing.T) {
+		reports := &progressReports{}
+		ctx := WithProgress(testContext(t), &CommandProgress{Report: reports.add})
+
+		_, err := RunCommand(ctx, "echo hello")
+		assert.NotError(t, err)
+		all := reports.all()
+		assert.Equal(t, len(all), 1)
+		check.Equal(t, all[0].LastLine, "hello")
+	})
+}
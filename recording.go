@@ -0,0 +1,249 @@
+package libfun
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+)
+
+// ErrNoRecording is returned by the ReplayExecutor when there is no
+// recording for a command.
+const ErrNoRecording ers.Error = "no recording for command"
+
+// CommandRecording captures a single execution of a command.
+type CommandRecording struct {
+	Args     []string          `json:"args"`
+	Dir      string            `json:"dir,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+	Stdin    string            `json:"stdin,omitempty"`
+	Stdout   string            `json:"stdout"`
+	Stderr   string            `json:"stderr"`
+	ExitCode int               `json:"exit_code"`
+	// Error holds the message of errors that were not caused by
+	// the command exiting unsuccessfully, such as the program not
+	// existing.
+	Error string `json:"error,omitempty"`
+	// NotFound records that the program did not exist, so that
+	// replayed errors match ErrCommandNotFound.
+	NotFound bool `json:"not_found,omitempty"`
+}
+
+// RecordingExecutor runs commands with another executor, and
+// records every execution in Dir for ReplayExecutor. Fixtures are
+// keyed on the arguments, environment, and standard input of the
+// command, but not the working directory.
+type RecordingExecutor struct {
+	// Dir is the directory for fixture files, which is created
+	// if needed.
+	Dir string
+	// Executor runs the commands, and defaults to
+	// JasperExecutor.
+	Executor Executor
+
+	mtx sync.Mutex
+}
+
+// NewRecordingExecutor constructs a RecordingExecutor that records
+// commands run by the JasperExecutor in the directory.
+func NewRecordingExecutor(dir string) *RecordingExecutor { return &RecordingExecutor{Dir: dir} }
+
+func (re *RecordingExecutor) Execute(ctx context.Context, c Command, stdout, stderr io.Writer) error {
+	var stdin []byte
+	if c.Stdin != nil {
+		var err error
+		if stdin, err = io.ReadAll(c.Stdin); err != nil {
+			return err
+		}
+		c.Stdin = bytes.NewReader(stdin)
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	base := re.Executor
+	if base == nil {
+		base = JasperExecutor()
+	}
+
+	err := base.Execute(ctx, c, io.MultiWriter(stdout, &stdoutBuf), io.MultiWriter(stderr, &stderrBuf))
+
+	rec := CommandRecording{
+		Args:     c.Args,
+		Dir:      c.Dir,
+		Env:      c.Env,
+		Stdin:    string(stdin),
+		Stdout:   stdoutBuf.String(),
+		Stderr:   stderrBuf.String(),
+		ExitCode: exitCode(err),
+	}
+	if err != nil && rec.ExitCode < 0 {
+		rec.Error = err.Error()
+		rec.NotFound = errors.Is(err, exec.ErrNotFound)
+	}
+
+	if rerr := re.record(rec); rerr != nil {
+		return ers.Wrapf(rerr, "recording %q", c.String())
+	}
+
+	return err
+}
+
+func (re *RecordingExecutor) record(rec CommandRecording) error {
+	re.mtx.Lock()
+	defer re.mtx.Unlock()
+
+	path := recordingPath(re.Dir, rec.Args, rec.Env, []byte(rec.Stdin))
+
+	recs, err := readRecordings(path)
+	if err = ignoreNotExist(err); err != nil {
+		return err
+	}
+	recs = append(recs, rec)
+
+	if err := os.MkdirAll(re.Dir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// ReplayExecutor serves the output of commands from the fixtures
+// produced by a RecordingExecutor, without running any processes.
+// Repeated recordings replay in order, and the last one repeats.
+type ReplayExecutor struct {
+	// Dir is the directory that holds the fixture files.
+	Dir string
+
+	mtx   sync.Mutex
+	calls map[string]int
+}
+
+// NewReplayExecutor constructs a ReplayExecutor for the fixtures in
+// the directory.
+func NewReplayExecutor(dir string) *ReplayExecutor { return &ReplayExecutor{Dir: dir} }
+
+func (rp *ReplayExecutor) Execute(ctx context.Context, c Command, stdout, stderr io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var stdin []byte
+	if c.Stdin != nil {
+		var err error
+		if stdin, err = io.ReadAll(c.Stdin); err != nil {
+			return err
+		}
+	}
+
+	rec, err := rp.next(recordingPath(rp.Dir, c.Args, c.Env, stdin))
+	if err != nil {
+		return ers.Wrapf(err, "replaying %q", c.String())
+	}
+
+	if _, err := io.WriteString(stdout, rec.Stdout); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(stderr, rec.Stderr); err != nil {
+		return err
+	}
+
+	switch {
+	case rec.NotFound:
+		return erc.Join(ers.Error(rec.Error), exec.ErrNotFound)
+	case rec.Error != "":
+		return ers.Error(rec.Error)
+	case rec.ExitCode != 0:
+		return replayExitError(rec.ExitCode)
+	default:
+		return nil
+	}
+}
+
+func (rp *ReplayExecutor) next(path string) (*CommandRecording, error) {
+	rp.mtx.Lock()
+	defer rp.mtx.Unlock()
+
+	recs, err := readRecordings(path)
+	if err != nil {
+		if ignoreNotExist(err) == nil {
+			return nil, ErrNoRecording
+		}
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, ErrNoRecording
+	}
+
+	if rp.calls == nil {
+		rp.calls = map[string]int{}
+	}
+	idx := min(rp.calls[path], len(recs)-1)
+	rp.calls[path]++
+
+	return &recs[idx], nil
+}
+
+// replayExitError reports the exit code of a replayed command, and
+// mirrors the behavior of *exec.ExitError.
+type replayExitError int
+
+func (e replayExitError) Error() string { return fmt.Sprint("exit status ", int(e)) }
+func (e replayExitError) ExitCode() int { return int(e) }
+
+func readRecordings(path string) ([]CommandRecording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var recs []CommandRecording
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return nil, ers.Wrapf(err, "reading recordings from %q", path)
+	}
+
+	return recs, nil
+}
+
+func recordingPath(dir string, args []string, env map[string]string, stdin []byte) string {
+	hash := sha256.New()
+	write := func(s string) { _, _ = io.WriteString(hash, s); _, _ = hash.Write([]byte{0}) }
+
+	write(strconv.Itoa(len(args)))
+	for _, arg := range args {
+		write(arg)
+	}
+
+	write(strconv.Itoa(len(env)))
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		write(key)
+		write(env[key])
+	}
+
+	_, _ = hash.Write(stdin)
+
+	name := hex.EncodeToString(hash.Sum(nil))[:16]
+	if len(args) > 0 {
+		name = fmt.Sprint(filepath.Base(args[0]), "-", name)
+	}
+
+	return filepath.Join(dir, name+".json")
+}
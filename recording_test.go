@@ -0,0 +1,111 @@
+package libfun
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+)
+
+func TestExecutor(t *testing.T) {
+	t.Run("CommandField", func(t *testing.T) {
+		var seen []string
+		c := MakeCommand("libfun-command-does-not-exist", "arg")
+		c.Executor = ExecutorFunc(func(_ context.Context, c Command, stdout, _ io.Writer) error {
+			seen = c.Args
+			_, err := io.WriteString(stdout, "fake\n")
+			return err
+		})
+
+		out, err := c.Output(t.Context())
+		assert.NotError(t, err)
+		check.Equal(t, string(out), "fake\n")
+		check.EqualItems(t, seen, []string{"libfun-command-does-not-exist", "arg"})
+	})
+	t.Run("Context", func(t *testing.T) {
+		ctx := WithExecutor(t.Context(), ExecutorFunc(func(_ context.Context, _ Command, _, stderr io.Writer) error {
+			_, _ = io.WriteString(stderr, "failed\n")
+			return replayExitError(2)
+		}))
+
+		_, err := RunCommand(ctx, "libfun-command-does-not-exist")
+		var eo *ErrOutput
+		assert.True(t, errors.As(err, &eo))
+		check.Equal(t, eo.ExitCode, 2)
+		check.Equal(t, eo.Err, "failed\n")
+	})
+}
+
+func TestRecording(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		dir := t.TempDir()
+		rec := WithExecutor(testContext(t), NewRecordingExecutor(dir))
+
+		seq, err := RunCommandWithInput(rec, "sort", strings.NewReader("b\na\n"))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"a", "b"})
+
+		_, err = RunCommand(rec, "sh -c 'echo out; echo err >&2; exit 3'")
+		assert.Error(t, err)
+
+		// replaying does not require a jasper manager, and never
+		// runs processes.
+		play := WithExecutor(t.Context(), NewReplayExecutor(dir))
+
+		seq, err = RunCommandWithInput(play, "sort", strings.NewReader("b\na\n"))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"a", "b"})
+
+		_, err = RunCommand(play, "sh -c 'echo out; echo err >&2; exit 3'")
+		var eo *ErrOutput
+		assert.True(t, errors.As(err, &eo))
+		check.Equal(t, eo.ExitCode, 3)
+		check.Equal(t, eo.Out, "out\n")
+		check.Equal(t, eo.Err, "err\n")
+
+		_, err = RunCommandWithInput(play, "sort", strings.NewReader("c\n"))
+		check.ErrorIs(t, err, ErrNoRecording)
+	})
+	t.Run("StartFailure", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := RunCommand(WithExecutor(testContext(t), NewRecordingExecutor(dir)), "libfun-command-does-not-exist")
+		assert.Error(t, err)
+
+		_, rerr := RunCommand(WithExecutor(t.Context(), NewReplayExecutor(dir)), "libfun-command-does-not-exist")
+		var eo *ErrOutput
+		assert.True(t, errors.As(rerr, &eo))
+		check.Equal(t, eo.ExitCode, -1)
+		check.Substring(t, rerr.Error(), "not found")
+	})
+	t.Run("Sequence", func(t *testing.T) {
+		dir := t.TempDir()
+		path := dir + "/count"
+		rec := WithExecutor(testContext(t), NewRecordingExecutor(dir))
+		cmd := "sh -c 'echo x >> " + path + "; wc -l < " + path + "'"
+
+		for range 2 {
+			_, err := RunCommand(rec, cmd)
+			assert.NotError(t, err)
+		}
+		assert.NotError(t, os.Remove(path))
+
+		play := WithExecutor(t.Context(), NewReplayExecutor(dir))
+		for _, expected := range []string{"1", "2", "2"} {
+			seq, err := RunCommand(play, cmd)
+			assert.NotError(t, err)
+			assert.EqualItems(t, irt.Collect(seq), []string{expected})
+		}
+	})
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		_, err := RunCommand(WithExecutor(ctx, NewReplayExecutor("testdata/rg")), "rg")
+		check.ErrorIs(t, err, context.Canceled)
+	})
+}
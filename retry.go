@@ -0,0 +1,160 @@
+package libfun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+)
+
+// RetryPolicy describes how commands are retried when they fail. The
+// zero value disables retries. Commands that do not exist are not
+// retried, unless Retryable says otherwise, and commands with a
+// Stdin can only be retried if it is an io.Seeker (e.g. a
+// strings.Reader or a file), which is rewound for each attempt.
+type RetryPolicy struct {
+	// Attempts is the maximum number of times the command runs,
+	// including the first attempt. Values less than 2 disable
+	// retries.
+	Attempts int
+	// Delay is the time to wait before the first retry. The
+	// delay doubles after each subsequent attempt.
+	Delay time.Duration
+	// MaxDelay, when positive, caps the delay between attempts.
+	MaxDelay time.Duration
+	// Jitter is the fraction, between 0 and 1, of each delay
+	// that is randomized, to avoid retrying many commands in
+	// lockstep.
+	Jitter float64
+	// ExitCodes, when specified, limits retries to failures with
+	// one of these exit codes.
+	ExitCodes []int
+	// Stderr, when specified, limits retries to failures where
+	// the standard error of the command matches the expression.
+	// When both are specified, failures must satisfy both
+	// ExitCodes and Stderr.
+	Stderr *regexp.Regexp
+	// Retryable, when specified, decides if a failure should be
+	// retried, and takes precedence over ExitCodes and Stderr.
+	Retryable func(*ErrOutput) bool
+}
+
+// ErrAttempts is returned when a command with a retry policy fails,
+// and records the output of every attempt in order. The final
+// attempt is available via errors.Unwrap and errors.As.
+type ErrAttempts struct {
+	Attempts []*ErrOutput
+}
+
+func (e *ErrAttempts) Error() string {
+	return fmt.Sprintf("failed after %d attempt(s): %v", len(e.Attempts), e.Unwrap())
+}
+
+func (e *ErrAttempts) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1]
+}
+
+// RunCommandWithRetry is the same as RunCommand, but retries the
+// command according to the policy.
+func RunCommandWithRetry(ctx context.Context, cmd string, policy RetryPolicy) (iter.Seq[string], error) {
+	c, err := ParseCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	c.Retry = policy
+
+	return c.Lines(ctx)
+}
+
+func (rp RetryPolicy) enabled() bool { return rp.Attempts > 1 }
+
+func (rp RetryPolicy) retryable(eo *ErrOutput) bool {
+	switch {
+	case rp.Retryable != nil:
+		return rp.Retryable(eo)
+	case errors.Is(eo, ErrCommandNotFound):
+		return false
+	case len(rp.ExitCodes) > 0 && !slices.Contains(rp.ExitCodes, eo.ExitCode):
+		return false
+	case rp.Stderr != nil && !rp.Stderr.MatchString(eo.Err):
+		return false
+	default:
+		return true
+	}
+}
+
+func (rp RetryPolicy) delay(attempt int) time.Duration {
+	dur := rp.Delay
+	for range attempt - 1 {
+		if (rp.MaxDelay > 0 && dur >= rp.MaxDelay) || dur > math.MaxInt64/2 {
+			break
+		}
+		dur *= 2
+	}
+	if rp.MaxDelay > 0 {
+		dur = min(dur, rp.MaxDelay)
+	}
+
+	if jitter := time.Duration(float64(dur) * min(max(rp.Jitter, 0), 1)); jitter > 0 {
+		dur -= rand.N(jitter)
+	}
+
+	return dur
+}
+
+// do runs the operation until it succeeds, returns an error that is
+// not retryable, or exhausts the attempts in the policy.
+func (rp RetryPolicy) do(ctx context.Context, stdin io.Reader, op func() ([]byte, error)) ([]byte, error) {
+	if !rp.enabled() {
+		return op()
+	}
+
+	record := &ErrAttempts{}
+
+	for attempt := 1; ; attempt++ {
+		out, err := op()
+		if err == nil {
+			return out, nil
+		}
+
+		var eo *ErrOutput
+		if !errors.As(err, &eo) {
+			return nil, err
+		}
+		record.Attempts = append(record.Attempts, eo)
+
+		if attempt >= rp.Attempts || !rp.retryable(eo) || ctx.Err() != nil {
+			return nil, record
+		}
+
+		// the input is rewound, so that each attempt receives
+		// the same input.
+		if stdin != nil {
+			seeker, ok := stdin.(io.Seeker)
+			if !ok {
+				return nil, erc.Join(record, ers.Wrapf(ErrUndefinedOperation, "cannot retry with input of type %T", stdin))
+			}
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, erc.Join(record, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, erc.Join(record, ctx.Err())
+		case <-time.After(rp.delay(attempt)):
+		}
+	}
+}
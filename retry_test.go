@@ -0,0 +1,155 @@
+package libfun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+)
+
+// flakyCommand returns a command that fails, writing the attempt
+// number to standard error, until it has run the specified number
+// of times.
+func flakyCommand(t *testing.T, succeedOn int) Command {
+	counter := filepath.Join(t.TempDir(), "count")
+	return MakeCommand("sh", "-c", fmt.Sprintf(
+		`echo x >> %[1]s; n=$(wc -l < %[1]s); if [ $n -lt %[2]d ]; then echo "attempt $n" >&2; exit 75; fi; echo ok`,
+		counter, succeedOn,
+	))
+}
+
+func TestRetry(t *testing.T) {
+	t.Run("EventuallySucceeds", func(t *testing.T) {
+		cmd := flakyCommand(t, 3)
+		cmd.Retry = RetryPolicy{Attempts: 5, Delay: time.Millisecond}
+		seq, err := cmd.Lines(testContext(t))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"ok"})
+	})
+	t.Run("Exhausted", func(t *testing.T) {
+		cmd := flakyCommand(t, 10)
+		cmd.Retry = RetryPolicy{Attempts: 3, Delay: time.Millisecond, Jitter: 0.5}
+		err := cmd.Run(testContext(t))
+
+		var ea *ErrAttempts
+		assert.True(t, errors.As(err, &ea))
+		assert.Equal(t, len(ea.Attempts), 3)
+		for idx, attempt := range ea.Attempts {
+			check.Equal(t, attempt.ExitCode, 75)
+			check.Equal(t, attempt.Err, fmt.Sprintf("attempt %d\n", idx+1))
+		}
+
+		var eo *ErrOutput
+		assert.True(t, errors.As(err, &eo))
+		check.Equal(t, eo.Err, "attempt 3\n")
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		err := flakyCommand(t, 2).Run(testContext(t))
+		var ea *ErrAttempts
+		check.True(t, !errors.As(err, &ea))
+		var eo *ErrOutput
+		check.True(t, errors.As(err, &eo))
+	})
+	t.Run("ExitCodes", func(t *testing.T) {
+		cmd := flakyCommand(t, 3)
+		cmd.Retry = RetryPolicy{Attempts: 5, ExitCodes: []int{1, 2}}
+		var ea *ErrAttempts
+		assert.True(t, errors.As(cmd.Run(testContext(t)), &ea))
+		check.Equal(t, len(ea.Attempts), 1)
+
+		cmd = flakyCommand(t, 3)
+		cmd.Retry = RetryPolicy{Attempts: 5, ExitCodes: []int{75}}
+		check.NotError(t, cmd.Run(testContext(t)))
+	})
+	t.Run("Stderr", func(t *testing.T) {
+		cmd := flakyCommand(t, 3)
+		cmd.Retry = RetryPolicy{Attempts: 5, Stderr: regexp.MustCompile("^attempt 1")}
+		var ea *ErrAttempts
+		assert.True(t, errors.As(cmd.Run(testContext(t)), &ea))
+		check.Equal(t, len(ea.Attempts), 2)
+
+		// failures must match both the exit codes and stderr.
+		cmd = flakyCommand(t, 3)
+		cmd.Retry = RetryPolicy{Attempts: 5, ExitCodes: []int{1}, Stderr: regexp.MustCompile("^attempt")}
+		assert.True(t, errors.As(cmd.Run(testContext(t)), &ea))
+		check.Equal(t, len(ea.Attempts), 1)
+
+		cmd = flakyCommand(t, 3)
+		cmd.Retry = RetryPolicy{Attempts: 5, ExitCodes: []int{75}, Stderr: regexp.MustCompile("^attempt 1")}
+		assert.True(t, errors.As(cmd.Run(testContext(t)), &ea))
+		check.Equal(t, len(ea.Attempts), 2)
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		cmd := MakeCommand("libfun-command-does-not-exist")
+		cmd.Retry = RetryPolicy{Attempts: 3}
+		var ea *ErrAttempts
+		assert.True(t, errors.As(cmd.Run(testContext(t)), &ea))
+		check.Equal(t, len(ea.Attempts), 1)
+	})
+	t.Run("Stdin", func(t *testing.T) {
+		cmd := flakyCommand(t, 2)
+		cmd.Stdin = strings.NewReader("input")
+		cmd.Retry = RetryPolicy{Attempts: 3}
+		check.NotError(t, cmd.Run(testContext(t)))
+
+		cmd = flakyCommand(t, 2)
+		cmd.Stdin = io.MultiReader(strings.NewReader("input"))
+		cmd.Retry = RetryPolicy{Attempts: 3}
+		err := cmd.Run(testContext(t))
+		check.ErrorIs(t, err, ErrUndefinedOperation)
+		var ea *ErrAttempts
+		assert.True(t, errors.As(err, &ea))
+		check.Equal(t, len(ea.Attempts), 1)
+	})
+	t.Run("Predicate", func(t *testing.T) {
+		cmd := flakyCommand(t, 3)
+		count := 0
+		cmd.Retry = RetryPolicy{Attempts: 5, Retryable: func(*ErrOutput) bool { count++; return true }}
+		check.NotError(t, cmd.Run(testContext(t)))
+		check.Equal(t, count, 2)
+	})
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testContext(t))
+		cmd := flakyCommand(t, 10)
+		cmd.Retry = RetryPolicy{Attempts: 5, Delay: time.Hour}
+		time.AfterFunc(50*time.Millisecond, cancel)
+		assert.MaxRuntime(t, 5*time.Second, func() {
+			err := cmd.Run(ctx)
+			check.ErrorIs(t, err, context.Canceled)
+		})
+	})
+	t.Run("Delay", func(t *testing.T) {
+		policy := RetryPolicy{Delay: time.Second, MaxDelay: 5 * time.Second}
+		check.Equal(t, policy.delay(1), time.Second)
+		check.Equal(t, policy.delay(2), 2*time.Second)
+		check.Equal(t, policy.delay(3), 4*time.Second)
+		check.Equal(t, policy.delay(4), 5*time.Second)
+		check.Equal(t, policy.delay(100), 5*time.Second)
+
+		// without a maximum, the delay stops growing rather
+		// than overflowing.
+		policy = RetryPolicy{Delay: time.Second}
+		check.True(t, policy.delay(100) >= policy.delay(40))
+		check.True(t, policy.delay(100) > 0)
+
+		policy = RetryPolicy{Delay: time.Second, MaxDelay: 5 * time.Second, Jitter: 0.5}
+		for attempt := range 10 {
+			dur := policy.delay(attempt + 1)
+			check.True(t, dur <= 5*time.Second && dur > 0)
+		}
+	})
+	t.Run("Wrapper", func(t *testing.T) {
+		seq, err := RunCommandWithRetry(testContext(t), "echo hi", RetryPolicy{Attempts: 2})
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"hi"})
+	})
+}
@@ -3,18 +3,26 @@ package libfun
 import (
 	"bytes"
 	"context"
+	"errors"
 	"iter"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
 	"github.com/tychoish/fun/irt"
 	"github.com/tychoish/fun/stw"
 	"github.com/tychoish/grip"
-	"github.com/tychoish/grip/level"
-	"github.com/tychoish/grip/send"
+	"github.com/tychoish/grip/message"
 	"github.com/tychoish/jasper"
 	"github.com/tychoish/jasper/util"
 )
 
+// ErrInvalidRipgrepArgs is returned by ripgrep operations when the
+// RipgrepArgs are invalid or contradictory.
+const ErrInvalidRipgrepArgs ers.Error = "invalid ripgrep arguments"
+
 type RipgrepArgs struct {
 	Types         []string
 	ExcludedTypes []string
@@ -26,6 +34,72 @@ type RipgrepArgs struct {
 	Invert        bool
 	Zip           bool
 	WordRegexp    bool
+
+	// Globs limits the search to files that match at least one of
+	// the globs, and ExcludedGlobs excludes the files and
+	// directories that match any of them. Globs use the syntax of
+	// .gitignore files, and take precedence over ignore files and
+	// Hidden. GlobCaseInsensitive matches all globs without regard
+	// to case (--iglob).
+	Globs               []string
+	ExcludedGlobs       []string
+	GlobCaseInsensitive bool
+	// Hidden searches hidden files and directories, and Follow
+	// follows symbolic links.
+	Hidden bool
+	Follow bool
+	// MaxDepth, when positive, limits the depth of directories
+	// that are searched: with a MaxDepth of 1, only the files in
+	// Path are searched.
+	MaxDepth int
+	// IgnoreCase matches without regard to case. SmartCase
+	// matches without regard to case only if the regular
+	// expression is all lowercase. They are mutually exclusive.
+	IgnoreCase bool
+	SmartCase  bool
+	// FixedStrings treats Regexp as a literal string.
+	FixedStrings bool
+	// Multiline allows matches to span lines. The Text of a
+	// RipgrepMatch for a multiline match contains every line of
+	// the match.
+	Multiline bool
+	// MaxFilesize, when positive, skips files larger than the
+	// size in bytes.
+	MaxFilesize int64
+	// NoIgnore disables all ignore files except the IgnoreFile.
+	// The other NoIgnore options disable subsets of ignore
+	// files: version control ignore files (.gitignore) with
+	// NoIgnoreVCS, .ignore and .rgignore files with NoIgnoreDot,
+	// ignore files in the parent directories of Path with
+	// NoIgnoreParent, the global git ignore file with
+	// NoIgnoreGlobal, and the IgnoreFile with NoIgnoreFiles,
+	// which cannot be combined with an IgnoreFile.
+	NoIgnore       bool
+	NoIgnoreVCS    bool
+	NoIgnoreDot    bool
+	NoIgnoreParent bool
+	NoIgnoreGlobal bool
+	NoIgnoreFiles  bool
+	// MaxCount, when positive, limits the number of matching
+	// lines in each file that RipgrepMatches reports.
+	MaxCount int
+
+	// BeforeContext and AfterContext are the number of lines of
+	// context around each match that RipgrepMatches reports. Ripgrep
+	// ignores them.
+	BeforeContext int
+	AfterContext  int
+
+	// Engine selects the implementation of the search: by
+	// default the rg program, which must be installed.
+	Engine RipgrepEngine
+
+	// Cache, when specified, serves results from the cache
+	// rather than running ripgrep when possible.
+	Cache *CommandCache
+	// Tags are added to the ripgrep process, and to its audit
+	// records (see CommandAudit).
+	Tags []string
 }
 
 // Ripgrep runs a ripgrep operation using the provided jasper
@@ -33,23 +107,91 @@ type RipgrepArgs struct {
 // ripgrep finds that matches regexp provided.
 //
 // The iterator only provides access to the fully qualified filenames
-// not the contents of the operation.
+// not the contents of the operation; use RipgrepMatches for the
+// matching lines. When no files match the
+// iterator is empty and the error is nil; when ripgrep is not
+// installed the error matches ErrCommandNotFound, unless the Engine
+// is RipgrepAuto. The standard error of ripgrep, which reports files
+// that it cannot search, is logged at level.Error with the grip
+// logger attached to the context, or sent to the CommandTee attached
+// to the context.
 func Ripgrep(ctx context.Context, jpm jasper.Manager, args RipgrepArgs) (iter.Seq[string], error) {
 	args.Path = util.TryExpandHomedir(args.Path)
-	var buf bytes.Buffer
-	sender := send.MakeBytesBuffer(&buf)
-	sender.SetPriority(level.Info)
-	sender.SetName("ripgrep")
-	sender.SetErrorHandler(send.ErrorHandlerFromSender(grip.Sender()))
+
+	if err := args.Validate(); err != nil {
+		return nil, err
+	}
+
+	if args.Engine == RipgrepNative {
+		return ripgrepNative(ctx, args)
+	}
 
 	cmd := stw.Slice[string]{
 		"rg",
 		"--files-with-matches",
+		"--null",
 		"--line-buffered",
 		"--color=never",
 		"--trim",
 	}
+	cmd.Extend(irt.Slice(args.searchArgs()))
+
+	rg := Command{
+		Args:    cmd,
+		Dir:     args.Path,
+		Manager: jpm,
+		Cache:   args.Cache,
+		Split:   ScanNull,
+		Tags:    args.Tags,
+	}
+
+	// ripgrep reports the files that it cannot search on standard
+	// error without failing, so unless the context has a tee,
+	// which receives it, standard error is logged.
+	var stderr *bytes.Buffer
+	if rg.tee(ctx) == nil {
+		stderr = &bytes.Buffer{}
+		rg.Tee = &CommandTee{Stderr: stderr}
+	}
+
+	lines, err := rg.Lines(ctx)
+	if stderr != nil && stderr.Len() > 0 {
+		grip.Context(ctx).Error(message.Fields{"cmd": "rg", "dir": args.Path, "stderr": strings.TrimSpace(stderr.String())})
+	}
+
+	// ripgrep exits with 1 when nothing matches, and with 2 for
+	// errors.
+	if isRipgrepNoMatch(err) {
+		return func(func(string) bool) {}, nil
+	}
+	if args.Engine == RipgrepAuto && errors.Is(err, ErrCommandNotFound) {
+		return ripgrepNative(ctx, args)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return args.files(irt.Convert(lines, func(in string) string { return filepath.Join(args.Path, in) })), nil
+}
+
+// files converts the full paths of matching files to the results of
+// a ripgrep operation.
+func (args RipgrepArgs) files(seq iter.Seq[string]) iter.Seq[string] {
+	if args.Directories {
+		seq = irt.Convert(seq, filepath.Dir)
+	}
+
+	if args.Unique {
+		return irt.Unique(seq)
+	}
 
+	return seq
+}
+
+// searchArgs returns the arguments that select files and matches,
+// which are common to all ripgrep operations.
+func (args RipgrepArgs) searchArgs() []string {
+	cmd := stw.Slice[string]{}
 	for ty := range irt.Slice(args.Types) {
 		cmd.Extend(irt.Args("--type", ty))
 	}
@@ -68,30 +210,84 @@ func Ripgrep(ctx context.Context, jpm jasper.Manager, args RipgrepArgs) (iter.Se
 	if args.WordRegexp {
 		cmd.Push("--word-regexp")
 	}
+
+	glob := "--glob"
+	if args.GlobCaseInsensitive {
+		glob = "--iglob"
+	}
+	for g := range irt.Slice(args.Globs) {
+		cmd.Extend(irt.Args(glob, g))
+	}
+	for g := range irt.Slice(args.ExcludedGlobs) {
+		cmd.Extend(irt.Args(glob, "!"+g))
+	}
+
+	flags := []struct {
+		enabled bool
+		flag    string
+	}{
+		{args.Hidden, "--hidden"},
+		{args.Follow, "--follow"},
+		{args.IgnoreCase, "--ignore-case"},
+		{args.SmartCase, "--smart-case"},
+		{args.FixedStrings, "--fixed-strings"},
+		{args.Multiline, "--multiline"},
+		{args.NoIgnore, "--no-ignore"},
+		{args.NoIgnoreVCS, "--no-ignore-vcs"},
+		{args.NoIgnoreDot, "--no-ignore-dot"},
+		{args.NoIgnoreParent, "--no-ignore-parent"},
+		{args.NoIgnoreGlobal, "--no-ignore-global"},
+		{args.NoIgnoreFiles, "--no-ignore-files"},
+	}
+	for _, f := range flags {
+		if f.enabled {
+			cmd.Push(f.flag)
+		}
+	}
+
+	if args.MaxDepth > 0 {
+		cmd.Extend(irt.Args("--max-depth", strconv.Itoa(args.MaxDepth)))
+	}
+	if args.MaxFilesize > 0 {
+		cmd.Extend(irt.Args("--max-filesize", strconv.FormatInt(args.MaxFilesize, 10)))
+	}
+	if args.MaxCount > 0 {
+		cmd.Extend(irt.Args("--max-count", strconv.Itoa(args.MaxCount)))
+	}
+
 	cmd.Extend(irt.Args("--regexp", args.Regexp))
+	return cmd
+}
 
-	err := jpm.CreateCommand(ctx).
-		Directory(args.Path).
-		Add(cmd).
-		SetOutputSender(level.Info, sender).
-		SetErrorSender(level.Error, grip.Sender()).
-		Run(ctx)
-	if err != nil {
-		return nil, err
+// Validate checks that the arguments are consistent, and returns an
+// error that matches ErrInvalidRipgrepArgs if they are not. Ripgrep
+// and RipgrepMatches validate their arguments before searching.
+func (args RipgrepArgs) Validate() error {
+	ec := &erc.Collector{}
+	invalid := func(cond bool, tmpl string, vals ...any) {
+		ec.If(cond, ers.Wrapf(ErrInvalidRipgrepArgs, tmpl, vals...))
 	}
-	seq := irt.Convert(irt.ReadLines(&buf),
-		func(in string) string {
-			in = filepath.Join(args.Path, in)
-			if args.Directories {
-				return filepath.Dir(in)
-			}
-			return in
-		},
-	)
 
-	if args.Unique {
-		return irt.Unique(seq), nil
+	invalid(args.IgnoreCase && args.SmartCase, "IgnoreCase and SmartCase are mutually exclusive")
+	invalid(args.NoIgnoreFiles && args.IgnoreFile != "", "NoIgnoreFiles disables the IgnoreFile %q", args.IgnoreFile)
+	invalid(args.MaxDepth < 0, "MaxDepth %d is negative", args.MaxDepth)
+	invalid(args.MaxFilesize < 0, "MaxFilesize %d is negative", args.MaxFilesize)
+	invalid(args.MaxCount < 0, "MaxCount %d is negative", args.MaxCount)
+	invalid(args.BeforeContext < 0, "BeforeContext %d is negative", args.BeforeContext)
+	invalid(args.AfterContext < 0, "AfterContext %d is negative", args.AfterContext)
+	for g := range irt.Slice(args.Globs) {
+		invalid(g == "", "empty glob")
+		invalid(strings.HasPrefix(g, "!"), "glob %q is negated, use ExcludedGlobs", g)
 	}
+	for g := range irt.Slice(args.ExcludedGlobs) {
+		invalid(g == "", "empty excluded glob")
+		invalid(strings.HasPrefix(g, "!"), "excluded glob %q is negated", g)
+	}
+
+	return ec.Resolve()
+}
 
-	return seq, nil
+func isRipgrepNoMatch(err error) bool {
+	var eo *ErrOutput
+	return errors.As(err, &eo) && eo.Kind == ErrNonZeroExit && eo.ExitCode == 1
 }
@@ -2,46 +2,172 @@ package libfun
 
 import (
 	"context"
+	"io"
 	"testing"
-	"time"
 
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
 	"github.com/tychoish/grip"
-	"github.com/tychoish/grip/message"
+	"github.com/tychoish/grip/level"
+	"github.com/tychoish/grip/send"
 	"github.com/tychoish/jasper"
 )
 
 func TestRipgrep(t *testing.T) {
-	start := time.Now()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// the output of ripgrep is replayed from testdata/rg, so the
+	// test does not depend on ripgrep or on any particular tree.
+	ctx := WithExecutor(t.Context(), NewReplayExecutor("testdata/rg"))
 
 	jpm := jasper.NewManager(jasper.ManagerOptionSet(
 		jasper.ManagerOptions{
 			ID:           t.Name(),
 			Synchronized: true,
 			MaxProcs:     64,
-			// Tracker:      ft.Must(track.New(t.Name())),
 		}))
-	args := RipgrepArgs{
-		Types:       []string{"go"},
-		Regexp:      "go:generate",
-		Path:        "/home/tychoish/neon/cloud",
-		Directories: true,
-		Unique:      true,
-	}
-	iter, err := Ripgrep(ctx, jpm, args)
-	if err != nil {
-		t.Error(err)
-	}
-	count := 0
-
-	for val := range iter {
-		count++
-		grip.Info(val)
-	}
-	grip.Info(message.Fields{
-		"path":  args.Path,
-		"count": count,
-		"dur":   time.Since(start),
+
+	t.Run("Directories", func(t *testing.T) {
+		args := RipgrepArgs{
+			Types:       []string{"go"},
+			Regexp:      "go:generate",
+			Path:        "/src/project",
+			Directories: true,
+			Unique:      true,
+		}
+		seq, err := Ripgrep(ctx, jpm, args)
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{
+			"/src/project/cmd/tool",
+			"/src/project/internal/gen",
+		})
+	})
+	t.Run("Newlines", func(t *testing.T) {
+		seq, err := Ripgrep(ctx, jpm, RipgrepArgs{Regexp: "libfun-newline", Path: "/src/project"})
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{
+			"/src/project/docs/odd\nname.md",
+			"/src/project/README.md",
+		})
+	})
+	t.Run("NoMatches", func(t *testing.T) {
+		seq, err := Ripgrep(ctx, jpm, RipgrepArgs{Regexp: "libfun-no-matches", Path: "/src/project"})
+		assert.NotError(t, err)
+		assert.Equal(t, len(irt.Collect(seq)), 0)
+	})
+	t.Run("Warnings", func(t *testing.T) {
+		sender := send.MakeInternal()
+		ctx := grip.WithLogger(ctx, grip.NewLogger(sender))
+
+		seq, err := Ripgrep(ctx, jpm, RipgrepArgs{Regexp: "libfun-warning", Path: "/src/project"})
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"/src/project/README.md"})
+
+		assert.Equal(t, sender.Len(), 1)
+		msg := sender.GetMessage()
+		check.Equal(t, msg.Priority, level.Error)
+		check.Substring(t, msg.Rendered, "Permission denied")
+	})
+	t.Run("NotInstalled", func(t *testing.T) {
+		_, err := Ripgrep(ctx, jpm, RipgrepArgs{Regexp: "libfun-not-found", Path: "/src/project"})
+		assert.ErrorIs(t, err, ErrCommandNotFound)
+	})
+	t.Run("Unrecorded", func(t *testing.T) {
+		_, err := Ripgrep(ctx, jpm, RipgrepArgs{Regexp: "TODO", Path: "/src/project"})
+		assert.ErrorIs(t, err, ErrNoRecording)
+	})
+}
+
+func TestRipgrepArgs(t *testing.T) {
+	t.Run("Argv", func(t *testing.T) {
+		for _, tc := range []struct {
+			name string
+			args RipgrepArgs
+			want []string
+		}{
+			{
+				name: "Default",
+				args: RipgrepArgs{Regexp: "TODO"},
+				want: []string{"--regexp", "TODO"},
+			},
+			{
+				name: "Globs",
+				args: RipgrepArgs{Regexp: "TODO", Globs: []string{"*.go", "cmd/**"}, ExcludedGlobs: []string{"vendor/"}},
+				want: []string{"--glob", "*.go", "--glob", "cmd/**", "--glob", "!vendor/", "--regexp", "TODO"},
+			},
+			{
+				name: "GlobCaseInsensitive",
+				args: RipgrepArgs{Regexp: "TODO", Globs: []string{"*.md"}, ExcludedGlobs: []string{"README.md"}, GlobCaseInsensitive: true},
+				want: []string{"--iglob", "*.md", "--iglob", "!README.md", "--regexp", "TODO"},
+			},
+			{
+				name: "Flags",
+				args: RipgrepArgs{Regexp: "a.b", Hidden: true, Follow: true, SmartCase: true, FixedStrings: true, Multiline: true},
+				want: []string{"--hidden", "--follow", "--smart-case", "--fixed-strings", "--multiline", "--regexp", "a.b"},
+			},
+			{
+				name: "IgnoreCase",
+				args: RipgrepArgs{Regexp: "todo", IgnoreCase: true, WordRegexp: true},
+				want: []string{"--word-regexp", "--ignore-case", "--regexp", "todo"},
+			},
+			{
+				name: "NoIgnore",
+				args: RipgrepArgs{Regexp: "TODO", NoIgnore: true, NoIgnoreVCS: true, NoIgnoreDot: true, NoIgnoreParent: true, NoIgnoreGlobal: true, NoIgnoreFiles: true},
+				want: []string{"--no-ignore", "--no-ignore-vcs", "--no-ignore-dot", "--no-ignore-parent", "--no-ignore-global", "--no-ignore-files", "--regexp", "TODO"},
+			},
+			{
+				name: "Limits",
+				args: RipgrepArgs{Regexp: "TODO", MaxDepth: 2, MaxFilesize: 1 << 20, MaxCount: 5},
+				want: []string{"--max-depth", "2", "--max-filesize", "1048576", "--max-count", "5", "--regexp", "TODO"},
+			},
+			{
+				name: "Combined",
+				args: RipgrepArgs{Regexp: "TODO", Types: []string{"go"}, Invert: true, IgnoreFile: ".rgignore", Globs: []string{"*_test.go"}, Hidden: true, MaxDepth: 3},
+				want: []string{
+					"--type", "go", "--invert-match", "--ignore-file", ".rgignore",
+					"--glob", "*_test.go", "--hidden", "--max-depth", "3", "--regexp", "TODO",
+				},
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				assert.NotError(t, tc.args.Validate())
+				assert.EqualItems(t, tc.args.searchArgs(), tc.want)
+			})
+		}
+	})
+	t.Run("Validate", func(t *testing.T) {
+		for _, tc := range []struct {
+			name string
+			args RipgrepArgs
+		}{
+			{name: "Case", args: RipgrepArgs{IgnoreCase: true, SmartCase: true}},
+			{name: "NoIgnoreFiles", args: RipgrepArgs{NoIgnoreFiles: true, IgnoreFile: ".rgignore"}},
+			{name: "MaxDepth", args: RipgrepArgs{MaxDepth: -1}},
+			{name: "MaxFilesize", args: RipgrepArgs{MaxFilesize: -1}},
+			{name: "MaxCount", args: RipgrepArgs{MaxCount: -1}},
+			{name: "Context", args: RipgrepArgs{BeforeContext: -1}},
+			{name: "EmptyGlob", args: RipgrepArgs{Globs: []string{""}}},
+			{name: "NegatedGlob", args: RipgrepArgs{Globs: []string{"!*.go"}}},
+			{name: "NegatedExcludedGlob", args: RipgrepArgs{ExcludedGlobs: []string{"!*.go"}}},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				assert.ErrorIs(t, tc.args.Validate(), ErrInvalidRipgrepArgs)
+			})
+		}
+
+		args := RipgrepArgs{Regexp: "TODO", IgnoreCase: true, SmartCase: true, MaxCount: -1}
+		err := args.Validate()
+		assert.Substring(t, err.Error(), "mutually exclusive")
+		assert.Substring(t, err.Error(), "MaxCount")
+
+		// ripgrep is not run with invalid arguments.
+		ctx := WithExecutor(t.Context(), ExecutorFunc(func(context.Context, Command, io.Writer, io.Writer) error {
+			t.Error("should not run")
+			return nil
+		}))
+		_, err = Ripgrep(ctx, nil, args)
+		assert.ErrorIs(t, err, ErrInvalidRipgrepArgs)
+		for _, err := range RipgrepMatches(ctx, nil, args) {
+			assert.ErrorIs(t, err, ErrInvalidRipgrepArgs)
+		}
 	})
 }
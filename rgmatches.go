@@ -0,0 +1,247 @@
+package libfun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tychoish/fun/ers"
+	"github.com/tychoish/fun/irt"
+	"github.com/tychoish/fun/stw"
+	"github.com/tychoish/jasper"
+	"github.com/tychoish/jasper/util"
+)
+
+// RipgrepMatch is a line that matched a ripgrep search.
+type RipgrepMatch struct {
+	// Path is the full path of the file.
+	Path string
+	// Line is the (1-based) line number of the match, and Offset
+	// is the byte offset of the start of the line in the file.
+	Line   int
+	Offset int64
+	// Text is the content of the line, without the trailing
+	// newline.
+	Text string
+	// Submatches are the parts of the line that matched the
+	// regular expression. Inverted searches have no submatches.
+	Submatches []RipgrepSubmatch
+	// Before and After are the lines of context around the match,
+	// as requested by the BeforeContext and AfterContext
+	// arguments. Lines of context between two nearby matches
+	// appear in both matches.
+	Before []RipgrepContextLine
+	After  []RipgrepContextLine
+}
+
+// RipgrepSubmatch is a part of a line that matched the regular
+// expression. Start and End are the byte offsets of the submatch in
+// the line's Text.
+type RipgrepSubmatch struct {
+	Text  string
+	Start int
+	End   int
+}
+
+// RipgrepContextLine is a line of context around a RipgrepMatch.
+type RipgrepContextLine struct {
+	Line   int
+	Offset int64
+	Text   string
+}
+
+// RipgrepMatches runs a ripgrep search using the provided jasper
+// manager, and returns an iterator of the lines that match, with
+// their location, the spans of the line that matched, and any lines
+// of context. Matches are reported as ripgrep finds them, so the
+// iterator can begin yielding matches before ripgrep exits; stopping
+// iteration terminates ripgrep.
+//
+// When no files match the iterator is empty. When ripgrep fails, or
+// is not installed, the final element of the iterator is an error,
+// which matches ErrCommandNotFound if ripgrep is not installed,
+// unless the Engine is RipgrepAuto. Unlike Ripgrep, the Directories,
+// Unique, and Cache arguments have no effect.
+func RipgrepMatches(ctx context.Context, jpm jasper.Manager, args RipgrepArgs) iter.Seq2[RipgrepMatch, error] {
+	args.Path = util.TryExpandHomedir(args.Path)
+
+	if err := args.Validate(); err != nil {
+		return func(yield func(RipgrepMatch, error) bool) { yield(RipgrepMatch{}, err) }
+	}
+
+	if args.Engine == RipgrepNative {
+		return ripgrepNativeMatches(ctx, args)
+	}
+
+	cmd := stw.Slice[string]{
+		"rg",
+		"--json",
+		"--line-buffered",
+	}
+	if args.BeforeContext > 0 {
+		cmd.Extend(irt.Args("--before-context", strconv.Itoa(args.BeforeContext)))
+	}
+	if args.AfterContext > 0 {
+		cmd.Extend(irt.Args("--after-context", strconv.Itoa(args.AfterContext)))
+	}
+	cmd.Extend(irt.Slice(args.searchArgs()))
+
+	records := Command{
+		Args:    cmd,
+		Dir:     args.Path,
+		Manager: jpm,
+		Tags:    args.Tags,
+	}.stream(ctx, nil)
+
+	return func(yield func(RipgrepMatch, error) bool) {
+		mp := &ripgrepMatchParser{
+			root:   args.Path,
+			before: args.BeforeContext,
+			after:  args.AfterContext,
+		}
+
+		for rec, err := range records {
+			// ripgrep exits with 1 when nothing matches, and
+			// with 2 for errors.
+			if isRipgrepNoMatch(err) {
+				break
+			}
+			if args.Engine == RipgrepAuto && errors.Is(err, ErrCommandNotFound) {
+				for m, err := range ripgrepNativeMatches(ctx, args) {
+					if !yield(m, err) {
+						return
+					}
+				}
+				return
+			}
+			if err == nil {
+				err = mp.parse(rec)
+			}
+			if err != nil {
+				yield(RipgrepMatch{}, err)
+				return
+			}
+			for _, m := range mp.ready {
+				if !yield(m, nil) {
+					return
+				}
+			}
+			mp.ready = mp.ready[:0]
+		}
+
+		if mp.pending != nil {
+			yield(*mp.pending, nil)
+		}
+	}
+}
+
+// ripgrepData is the representation of paths and text in ripgrep's
+// JSON output, which encodes text that is not valid UTF-8 as
+// base64 in "bytes".
+type ripgrepData struct {
+	Text  *string `json:"text"`
+	Bytes []byte  `json:"bytes"`
+}
+
+func (rd ripgrepData) String() string {
+	if rd.Text != nil {
+		return *rd.Text
+	}
+	return string(rd.Bytes)
+}
+
+type ripgrepMessage struct {
+	Type string `json:"type"`
+	Data struct {
+		Path       ripgrepData `json:"path"`
+		Lines      ripgrepData `json:"lines"`
+		LineNumber int         `json:"line_number"`
+		Offset     int64       `json:"absolute_offset"`
+		Submatches []struct {
+			Match ripgrepData `json:"match"`
+			Start int         `json:"start"`
+			End   int         `json:"end"`
+		} `json:"submatches"`
+	} `json:"data"`
+}
+
+// ripgrepMatchParser assembles matches from the messages that
+// ripgrep writes. Because ripgrep reports context lines after a
+// match before the next match, each match is held until the next
+// match or the end of the file.
+type ripgrepMatchParser struct {
+	root   string
+	before int
+	after  int
+
+	pending *RipgrepMatch
+	end     int
+	recent  []RipgrepContextLine
+	ready   []RipgrepMatch
+}
+
+func (mp *ripgrepMatchParser) parse(rec []byte) error {
+	var msg ripgrepMessage
+	if err := json.Unmarshal(rec, &msg); err != nil {
+		return ers.Wrapf(err, "parsing ripgrep output %q", rec)
+	}
+
+	switch msg.Type {
+	case "match":
+		m := RipgrepMatch{
+			Path:   filepath.Join(mp.root, msg.Data.Path.String()),
+			Line:   msg.Data.LineNumber,
+			Offset: msg.Data.Offset,
+			Text:   trimNewline(msg.Data.Lines.String()),
+		}
+		for _, sm := range msg.Data.Submatches {
+			m.Submatches = append(m.Submatches, RipgrepSubmatch{Text: sm.Match.String(), Start: sm.Start, End: sm.End})
+		}
+		for _, cl := range mp.recent {
+			if cl.Line >= m.Line-mp.before && cl.Line < m.Line {
+				m.Before = append(m.Before, cl)
+			}
+		}
+		mp.recent = mp.recent[:0]
+		mp.flush()
+		mp.pending = &m
+		// multiline matches span more than one line.
+		mp.end = m.Line + strings.Count(m.Text, "\n")
+	case "context":
+		cl := RipgrepContextLine{
+			Line:   msg.Data.LineNumber,
+			Offset: msg.Data.Offset,
+			Text:   trimNewline(msg.Data.Lines.String()),
+		}
+		if mp.pending != nil && cl.Line <= mp.end+mp.after {
+			mp.pending.After = append(mp.pending.After, cl)
+		}
+		if mp.before > 0 {
+			mp.recent = append(mp.recent, cl)
+			if over := len(mp.recent) - mp.before; over > 0 {
+				mp.recent = append(mp.recent[:0], mp.recent[over:]...)
+			}
+		}
+	case "end":
+		mp.recent = mp.recent[:0]
+		mp.flush()
+	}
+
+	return nil
+}
+
+func (mp *ripgrepMatchParser) flush() {
+	if mp.pending != nil {
+		mp.ready = append(mp.ready, *mp.pending)
+		mp.pending = nil
+	}
+}
+
+func trimNewline(in string) string {
+	in = strings.TrimSuffix(in, "\n")
+	return strings.TrimSuffix(in, "\r")
+}
@@ -0,0 +1,142 @@
+package libfun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/jasper"
+)
+
+func TestRipgrepMatches(t *testing.T) {
+	// the output of ripgrep is replayed from testdata/rg, so the
+	// test does not depend on ripgrep or on any particular tree.
+	ctx := WithExecutor(t.Context(), NewReplayExecutor("testdata/rg"))
+
+	jpm := jasper.NewManager(jasper.ManagerOptionSet(
+		jasper.ManagerOptions{
+			ID:           t.Name(),
+			Synchronized: true,
+			MaxProcs:     64,
+		}))
+
+	collect := func(t *testing.T, args RipgrepArgs) ([]RipgrepMatch, error) {
+		t.Helper()
+		var out []RipgrepMatch
+		for m, err := range RipgrepMatches(ctx, jpm, args) {
+			if err != nil {
+				return out, err
+			}
+			out = append(out, m)
+		}
+		return out, nil
+	}
+
+	t.Run("Matches", func(t *testing.T) {
+		matches, err := collect(t, RipgrepArgs{Regexp: "TODO", Path: "/src/project"})
+		assert.NotError(t, err)
+		assert.Equal(t, len(matches), 3)
+
+		check.Equal(t, matches[0].Path, "/src/project/main.go")
+		check.Equal(t, matches[0].Line, 3)
+		check.Equal(t, matches[0].Offset, 14)
+		check.Equal(t, matches[0].Text, "// TODO: handle errors")
+		check.EqualItems(t, matches[0].Submatches, []RipgrepSubmatch{{Text: "TODO", Start: 3, End: 7}})
+		check.Equal(t, len(matches[0].Before), 0)
+		check.Equal(t, len(matches[0].After), 0)
+
+		check.Equal(t, matches[1].Line, 5)
+		check.Equal(t, matches[1].Text, "\tprintln(\"TODO\") // TODO")
+		check.EqualItems(t, matches[1].Submatches, []RipgrepSubmatch{
+			{Text: "TODO", Start: 10, End: 14},
+			{Text: "TODO", Start: 20, End: 24},
+		})
+		for _, sm := range matches[1].Submatches {
+			check.Equal(t, matches[1].Text[sm.Start:sm.End], sm.Text)
+		}
+
+		// paths that are not valid UTF-8 are base64 encoded.
+		check.Equal(t, matches[2].Path, "/src/project/docs/caf\xe9.txt")
+		check.Equal(t, matches[2].Line, 1)
+	})
+	t.Run("Context", func(t *testing.T) {
+		matches, err := collect(t, RipgrepArgs{Regexp: "TODO", Path: "/src/project", BeforeContext: 1, AfterContext: 1})
+		assert.NotError(t, err)
+		assert.Equal(t, len(matches), 2)
+
+		check.EqualItems(t, matches[0].Before, []RipgrepContextLine{{Line: 2, Offset: 13, Text: ""}})
+		check.EqualItems(t, matches[0].After, []RipgrepContextLine{{Line: 4, Offset: 37, Text: "func main() {"}})
+		// context between nearby matches belongs to both.
+		check.EqualItems(t, matches[1].Before, []RipgrepContextLine{{Line: 4, Offset: 37, Text: "func main() {"}})
+		check.EqualItems(t, matches[1].After, []RipgrepContextLine{{Line: 6, Offset: 76, Text: "}"}})
+	})
+	t.Run("EarlyReturn", func(t *testing.T) {
+		count := 0
+		for _, err := range RipgrepMatches(ctx, jpm, RipgrepArgs{Regexp: "TODO", Path: "/src/project"}) {
+			assert.NotError(t, err)
+			count++
+			break
+		}
+		check.Equal(t, count, 1)
+	})
+	t.Run("NoMatches", func(t *testing.T) {
+		matches, err := collect(t, RipgrepArgs{Regexp: "libfun-no-matches", Path: "/src/project"})
+		assert.NotError(t, err)
+		check.Equal(t, len(matches), 0)
+	})
+	t.Run("Error", func(t *testing.T) {
+		_, err := collect(t, RipgrepArgs{Regexp: "TODO(", Path: "/src/project"})
+		check.ErrorIs(t, err, ErrNonZeroExit)
+		check.Substring(t, err.Error(), "unclosed group")
+	})
+	t.Run("Unrecorded", func(t *testing.T) {
+		_, err := collect(t, RipgrepArgs{Regexp: "libfun-unrecorded", Path: "/src/project"})
+		check.ErrorIs(t, err, ErrNoRecording)
+	})
+	t.Run("LongLines", func(t *testing.T) {
+		// lines (and the messages that describe them) may be
+		// much larger than bufio.Scanner's default limit.
+		long := strings.Repeat("x", 70000) + "TODO"
+		ctx := WithExecutor(t.Context(), ExecutorFunc(func(_ context.Context, _ Command, stdout, _ io.Writer) error {
+			_, err := fmt.Fprintf(stdout, `{"type":"match","data":{"path":{"text":"long.txt"},"lines":{"text":"%s\n"},"line_number":1,"absolute_offset":0,"submatches":[{"match":{"text":"TODO"},"start":70000,"end":70004}]}}`+"\n", long)
+			return err
+		}))
+
+		var matches []RipgrepMatch
+		for m, err := range RipgrepMatches(ctx, jpm, RipgrepArgs{Regexp: "TODO", Path: "/src/project"}) {
+			assert.NotError(t, err)
+			matches = append(matches, m)
+		}
+		assert.Equal(t, len(matches), 1)
+		check.Equal(t, matches[0].Text, long)
+		check.EqualItems(t, matches[0].Submatches, []RipgrepSubmatch{{Text: "TODO", Start: 70000, End: 70004}})
+	})
+	t.Run("Parser", func(t *testing.T) {
+		mp := &ripgrepMatchParser{root: "/src"}
+		check.Error(t, mp.parse([]byte("not json")))
+		assert.NotError(t, mp.parse([]byte(`{"type":"match","data":{"path":{"text":"a.txt"},"lines":{"text":"crlf\r\n"},"line_number":7,"absolute_offset":40,"submatches":[]}}`)))
+		check.Equal(t, len(mp.ready), 0)
+		assert.NotError(t, mp.parse([]byte(`{"type":"end","data":{"path":{"text":"a.txt"}}}`)))
+		assert.Equal(t, len(mp.ready), 1)
+		check.Equal(t, mp.ready[0].Path, "/src/a.txt")
+		check.Equal(t, mp.ready[0].Text, "crlf")
+		check.Equal(t, len(mp.ready[0].Submatches), 0)
+	})
+	t.Run("Multiline", func(t *testing.T) {
+		mp := &ripgrepMatchParser{root: "/src", after: 1}
+		for _, rec := range []string{
+			`{"type":"match","data":{"path":{"text":"a.txt"},"lines":{"text":"one\ntwo\n"},"line_number":1,"absolute_offset":0,"submatches":[{"match":{"text":"one\ntwo"},"start":0,"end":7}]}}`,
+			`{"type":"context","data":{"path":{"text":"a.txt"},"lines":{"text":"three\n"},"line_number":3,"absolute_offset":8,"submatches":[]}}`,
+			`{"type":"end","data":{"path":{"text":"a.txt"}}}`,
+		} {
+			assert.NotError(t, mp.parse([]byte(rec)))
+		}
+		assert.Equal(t, len(mp.ready), 1)
+		check.Equal(t, mp.ready[0].Text, "one\ntwo")
+		check.EqualItems(t, mp.ready[0].After, []RipgrepContextLine{{Line: 3, Offset: 8, Text: "three"}})
+	})
+}
@@ -0,0 +1,724 @@
+package libfun
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"iter"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+)
+
+// ErrUnknownFileType is returned by the native ripgrep engine for
+// file types that it does not support.
+const ErrUnknownFileType ers.Error = "unknown file type"
+
+// ErrNativeUnsupported is returned by the native ripgrep engine for
+// arguments that it does not support.
+const ErrNativeUnsupported ers.Error = "not supported by the native ripgrep engine"
+
+// RipgrepEngine selects the implementation of Ripgrep and
+// RipgrepMatches.
+type RipgrepEngine int
+
+const (
+	// RipgrepBinary runs the rg program, and is the default.
+	RipgrepBinary RipgrepEngine = iota
+	// RipgrepAuto runs the rg program, or, when it is not
+	// installed, uses RipgrepNative.
+	RipgrepAuto
+	// RipgrepNative searches in process, with Go's regexp package,
+	// for environments without ripgrep. It follows ripgrep's
+	// defaults, with some differences:
+	//
+	//   - regular expressions use Go's syntax, which is similar,
+	//     but not identical, to ripgrep's default engine.
+	//   - the ignore files are those in the search path: .gitignore
+	//     (within git repositories), .ignore, and .rgignore, and
+	//     the IgnoreFile. Global and parent ignore files are not
+	//     used.
+	//   - files with a NUL byte in their first 8KiB are binary,
+	//     and are not searched.
+	//   - Zip searches files compressed with gzip and bzip2.
+	//   - only the types in RipgrepNativeTypes are supported.
+	//   - Follow and Multiline are not supported, and return
+	//     ErrNativeUnsupported. NoIgnoreParent and NoIgnoreGlobal
+	//     have no effect.
+	//   - files are searched, and results are reported, in lexical
+	//     order.
+	//   - files and directories that cannot be read for lack of
+	//     permission are skipped, rather than reported as errors.
+	//
+	// Hidden files are skipped, and symbolic links are not
+	// followed, as with ripgrep.
+	RipgrepNative
+)
+
+// RipgrepNativeTypes returns the file types that the native ripgrep
+// engine supports, and their globs, which match those of ripgrep.
+func RipgrepNativeTypes() map[string][]string { return maps.Clone(ripgrepTypes) }
+
+var ripgrepTypes = map[string][]string{
+	"c":        {"*.[chH]", "*.[chH].in", "*.cats"},
+	"cpp":      {"*.[ChH]", "*.cc", "*.[ch]pp", "*.[ch]xx", "*.hh", "*.inl", "*.[ChH].in", "*.cc.in", "*.[ch]pp.in", "*.[ch]xx.in", "*.hh.in"},
+	"css":      {"*.css", "*.scss"},
+	"go":       {"*.go"},
+	"html":     {"*.htm", "*.html", "*.ejs"},
+	"java":     {"*.java", "*.jsp", "*.jspx", "*.properties"},
+	"js":       {"*.js", "*.jsx", "*.vue", "*.cjs", "*.mjs"},
+	"json":     {"*.json", "composer.lock", "*.sarif"},
+	"lua":      {"*.lua"},
+	"make":     {"[Gg][Nn][Uu]makefile", "[Mm]akefile", "[Gg][Nn][Uu]makefile.am", "[Mm]akefile.am", "[Gg][Nn][Uu]makefile.in", "[Mm]akefile.in", "*.mk", "*.mak"},
+	"markdown": {"*.markdown", "*.md", "*.mdown", "*.mdwn", "*.mkd", "*.mkdn", "*.mdx"},
+	"md":       {"*.markdown", "*.md", "*.mdown", "*.mdwn", "*.mkd", "*.mkdn", "*.mdx"},
+	"proto":    {"*.proto"},
+	"py":       {"*.py", "*.pyi"},
+	"ruby":     {"Gemfile", "*.gemspec", ".irbrc", "Rakefile", "*.rb"},
+	"rust":     {"*.rs"},
+	"sh":       {"*.bash", ".bashrc", "*.csh", ".cshrc", "*.ksh", ".kshrc", "*.sh", ".zshrc", "*.zsh"},
+	"sql":      {"*.sql", "*.psql"},
+	"toml":     {"*.toml", "Cargo.lock"},
+	"ts":       {"*.ts", "*.tsx", "*.cts", "*.mts"},
+	"txt":      {"*.txt"},
+	"yaml":     {"*.yaml", "*.yml"},
+}
+
+func ripgrepNative(ctx context.Context, args RipgrepArgs) (iter.Seq[string], error) {
+	rs, err := newRipgrepSearcher(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for file := range rs.walk(ctx) {
+		err := rs.search(file, func(line ripgrepLine) bool {
+			if line.match {
+				files = append(files, file)
+			}
+			return !line.match
+		})
+		rs.ec.Push(err)
+	}
+
+	if err := erc.Join(rs.ec.Resolve(), ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	return args.files(slices.Values(files)), nil
+}
+
+func ripgrepNativeMatches(ctx context.Context, args RipgrepArgs) iter.Seq2[RipgrepMatch, error] {
+	return func(yield func(RipgrepMatch, error) bool) {
+		rs, err := newRipgrepSearcher(args)
+		if err != nil {
+			yield(RipgrepMatch{}, err)
+			return
+		}
+
+		for file := range rs.walk(ctx) {
+			var (
+				pending *RipgrepMatch
+				recent  []RipgrepContextLine
+				after   int
+				count   int
+				stopped bool
+			)
+
+			rs.ec.Push(rs.search(file, func(line ripgrepLine) bool {
+				// after the last match, only report its context.
+				if args.MaxCount > 0 && count >= args.MaxCount && (line.match || after == 0) {
+					return false
+				}
+
+				if !line.match {
+					cl := RipgrepContextLine{Line: line.number, Offset: line.offset, Text: trimNewline(string(line.text))}
+					if pending != nil && after > 0 {
+						pending.After = append(pending.After, cl)
+						after--
+					}
+					if args.BeforeContext > 0 {
+						recent = append(recent, cl)
+						if over := len(recent) - args.BeforeContext; over > 0 {
+							recent = slices.Delete(recent, 0, over)
+						}
+					}
+					return true
+				}
+
+				if pending != nil && !yield(*pending, nil) {
+					stopped = true
+					return false
+				}
+
+				text := strings.TrimSuffix(string(line.text), "\n")
+				pending = &RipgrepMatch{
+					Path:   file,
+					Line:   line.number,
+					Offset: line.offset,
+					Text:   trimNewline(text),
+					Before: recent,
+				}
+				for _, span := range line.spans {
+					pending.Submatches = append(pending.Submatches, RipgrepSubmatch{Text: text[span[0]:span[1]], Start: span[0], End: span[1]})
+				}
+				recent = nil
+				after = args.AfterContext
+				count++
+				return true
+			}))
+
+			if stopped || (pending != nil && !yield(*pending, nil)) {
+				return
+			}
+		}
+
+		if err := erc.Join(rs.ec.Resolve(), ctx.Err()); err != nil {
+			yield(RipgrepMatch{}, err)
+		}
+	}
+}
+
+// ripgrepSearcher implements the native ripgrep engine.
+type ripgrepSearcher struct {
+	args     RipgrepArgs
+	root     string
+	re       *regexp.Regexp
+	word     *regexp.Regexp
+	types    []string
+	excluded []string
+
+	overrides []ignoreRule
+	whitelist bool
+	global    []ignoreRule
+	ignores   map[string][]ignoreRule
+	repos     map[string]bool
+
+	ec erc.Collector
+}
+
+func newRipgrepSearcher(args RipgrepArgs) (*ripgrepSearcher, error) {
+	rs := &ripgrepSearcher{
+		args:    args,
+		root:    filepath.Clean(args.Path),
+		ignores: map[string][]ignoreRule{},
+		repos:   map[string]bool{},
+	}
+
+	// files and directories in the path that cannot be read are
+	// skipped, but the path itself must be readable.
+	f, err := os.Open(rs.root)
+	if err != nil {
+		return nil, err
+	}
+	_ = f.Close()
+
+	for _, unsupported := range []struct {
+		set  bool
+		name string
+	}{
+		{args.Follow, "Follow"},
+		{args.Multiline, "Multiline"},
+	} {
+		if unsupported.set {
+			return nil, ers.Wrap(ErrNativeUnsupported, unsupported.name)
+		}
+	}
+
+	pattern := args.Regexp
+	if args.FixedStrings {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if args.IgnoreCase || (args.SmartCase && !hasUppercase(args.Regexp)) {
+		pattern = "(?i)" + pattern
+	}
+
+	if rs.re, err = regexp.Compile(pattern); err != nil {
+		return nil, ers.Wrapf(err, "invalid regexp %q", args.Regexp)
+	}
+	if args.WordRegexp {
+		// the first group is the match, which must be followed
+		// by a non-word character or the end of the line.
+		if rs.word, err = regexp.Compile(`((?:` + pattern + `))(?:$|[^\pL\p{Nd}_])`); err != nil {
+			return nil, ers.Wrapf(err, "invalid regexp %q", args.Regexp)
+		}
+	}
+	if rs.types, err = ripgrepTypeGlobs(args.Types); err != nil {
+		return nil, err
+	}
+	if rs.excluded, err = ripgrepTypeGlobs(args.ExcludedTypes); err != nil {
+		return nil, err
+	}
+
+	for _, glob := range args.Globs {
+		if rule, ok := newIgnoreRule(glob, args.GlobCaseInsensitive); ok {
+			rule.negate = true
+			rs.overrides = append(rs.overrides, rule)
+			rs.whitelist = true
+		}
+	}
+	for _, glob := range args.ExcludedGlobs {
+		if rule, ok := newIgnoreRule(glob, args.GlobCaseInsensitive); ok {
+			rs.overrides = append(rs.overrides, rule)
+		}
+	}
+
+	if args.IgnoreFile != "" {
+		name := args.IgnoreFile
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(rs.root, name)
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		rs.global = parseIgnoreRules(string(data))
+	}
+
+	return rs, nil
+}
+
+func ripgrepTypeGlobs(types []string) ([]string, error) {
+	var globs []string
+	for _, ty := range types {
+		tg, ok := ripgrepTypes[ty]
+		if !ok {
+			return nil, ers.Wrapf(ErrUnknownFileType, "%q", ty)
+		}
+		globs = append(globs, tg...)
+	}
+	return globs, nil
+}
+
+func matchAnyGlob(globs []string, name string) bool {
+	return slices.ContainsFunc(globs, func(glob string) bool { ok, _ := filepath.Match(glob, name); return ok })
+}
+
+// walk returns the paths of the files to search, in lexical order.
+// Files and directories that cannot be read for lack of permission
+// are skipped; other errors are collected, and the walk continues.
+func (rs *ripgrepSearcher) walk(ctx context.Context) iter.Seq[string] {
+	opts := FsWalkOptions{Path: rs.root, SkipPermissionErrors: true, Errors: &rs.ec}
+	return FsWalkStream(opts, func(p string, d fs.DirEntry) (*string, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, fs.SkipAll
+		}
+
+		if p == rs.root && !d.IsDir() {
+			return &p, nil
+		}
+
+		if p != rs.root {
+			isDir := d.IsDir()
+			skip := func() (*string, error) {
+				if isDir {
+					return nil, fs.SkipDir
+				}
+				return nil, nil
+			}
+
+			// globs take precedence over all other filters.
+			matched, ignored := rs.override(p, isDir)
+			switch {
+			case matched && ignored:
+				return skip()
+			case matched:
+			case !rs.args.Hidden && strings.HasPrefix(d.Name(), "."), rs.ignored(p, isDir):
+				return skip()
+			case !isDir && len(rs.types) > 0 && !matchAnyGlob(rs.types, d.Name()):
+				return nil, nil
+			case !isDir && matchAnyGlob(rs.excluded, d.Name()):
+				return nil, nil
+			}
+
+			switch {
+			case isDir && rs.args.MaxDepth > 0 && rs.depth(p) >= rs.args.MaxDepth:
+				return nil, fs.SkipDir
+			case isDir:
+			case !d.Type().IsRegular():
+				return nil, nil
+			case rs.args.MaxFilesize > 0:
+				info, err := d.Info()
+				if err != nil {
+					return nil, ignoreNotExist(err)
+				}
+				if info.Size() > rs.args.MaxFilesize {
+					return nil, nil
+				}
+				return &p, nil
+			default:
+				return &p, nil
+			}
+		}
+
+		return nil, rs.loadIgnores(p)
+	})
+}
+
+func (rs *ripgrepSearcher) depth(p string) int {
+	rel, err := filepath.Rel(rs.root, p)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// override matches the path against the globs, and reports if any
+// glob matched, and if so, whether the path is ignored. When there
+// are include globs, files that match none of them are ignored.
+func (rs *ripgrepSearcher) override(p string, isDir bool) (matched, ignored bool) {
+	rel, err := filepath.Rel(rs.root, p)
+	if err != nil {
+		return false, false
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, rule := range slices.Backward(rs.overrides) {
+		if rule.match(rel, isDir) {
+			return true, !rule.negate
+		}
+	}
+	if rs.whitelist && !isDir {
+		return true, true
+	}
+	return false, false
+}
+
+// loadIgnores reads the ignore files of a directory, in increasing
+// order of precedence.
+func (rs *ripgrepSearcher) loadIgnores(dir string) error {
+	if rs.args.NoIgnore {
+		return nil
+	}
+
+	var names []string
+	if !rs.args.NoIgnoreVCS && rs.inRepo(dir) {
+		names = append(names, ".gitignore")
+	}
+	if !rs.args.NoIgnoreDot {
+		names = append(names, ".ignore", ".rgignore")
+	}
+
+	var rules []ignoreRule
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrPermission) {
+			continue
+		}
+		if err = ignoreNotExist(err); err != nil {
+			return err
+		}
+		rules = append(rules, parseIgnoreRules(string(data))...)
+	}
+	if len(rules) > 0 {
+		rs.ignores[dir] = rules
+	}
+	return nil
+}
+
+func (rs *ripgrepSearcher) inRepo(dir string) bool {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+
+	var walked []string
+	defer func() {
+		for _, d := range walked {
+			rs.repos[d] = rs.repos[abs]
+		}
+	}()
+
+	for {
+		if found, ok := rs.repos[abs]; ok {
+			return found
+		}
+		walked = append(walked, abs)
+
+		if _, err := os.Lstat(filepath.Join(abs, ".git")); err == nil {
+			rs.repos[abs] = true
+			return true
+		}
+
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			rs.repos[abs] = false
+			return false
+		}
+		abs = parent
+	}
+}
+
+// ignored reports if the path matches the ignore rules. Rules in
+// deeper directories take precedence, and within a set of rules, the
+// last matching rule wins.
+func (rs *ripgrepSearcher) ignored(p string, isDir bool) bool {
+	ignored := false
+	check := func(dir string, rules []ignoreRule) {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return
+		}
+		rel = filepath.ToSlash(rel)
+		for _, rule := range rules {
+			if rule.match(rel, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	check(rs.root, rs.global)
+
+	var dirs []string
+	for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == rs.root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	for _, dir := range slices.Backward(dirs) {
+		check(dir, rs.ignores[dir])
+	}
+
+	return ignored
+}
+
+// ripgrepLine is a line of a file; match reports if the line is a
+// match for the search, and spans holds the byte offsets of the
+// parts of the line that matched.
+type ripgrepLine struct {
+	number int
+	offset int64
+	text   []byte
+	match  bool
+	spans  [][]int
+}
+
+// search calls fn for each line of the file, until fn returns false.
+// Files that cannot be read for lack of permission are skipped.
+func (rs *ripgrepSearcher) search(file string, fn func(ripgrepLine) bool) error {
+	f, err := os.Open(file)
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return nil
+	case err != nil:
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if rs.args.Zip {
+		switch filepath.Ext(file) {
+		case ".gz", ".tgz":
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return ers.Wrapf(err, "decompressing %q", file)
+			}
+			defer gz.Close()
+			r = gz
+		case ".bz2", ".tbz2":
+			r = bzip2.NewReader(f)
+		}
+	}
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	if head, err := br.Peek(8 * 1024); bytes.IndexByte(head, 0) >= 0 {
+		return nil
+	} else if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return ers.Wrapf(err, "reading %q", file)
+	}
+
+	line := ripgrepLine{}
+	for {
+		text, err := br.ReadBytes('\n')
+		if len(text) > 0 {
+			line.number++
+			line.text = text
+			line.spans = rs.find(bytes.TrimSuffix(text, []byte("\n")))
+			line.match = len(line.spans) > 0
+			if rs.args.Invert {
+				line.match, line.spans = !line.match, nil
+			}
+			if !fn(line) {
+				return nil
+			}
+			line.offset += int64(len(text))
+		}
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return ers.Wrapf(err, "reading %q", file)
+		}
+	}
+}
+
+// find returns the spans of the line that match the regular
+// expression. Word searches only match at word boundaries: when a
+// match is not preceded by one, the search resumes at the next
+// character, so that overlapping matches are found as with ripgrep.
+func (rs *ripgrepSearcher) find(line []byte) [][]int {
+	if !rs.args.WordRegexp {
+		return rs.re.FindAllIndex(line, -1)
+	}
+
+	var spans [][]int
+	for pos := 0; pos <= len(line); {
+		loc := rs.word.FindSubmatchIndex(line[pos:])
+		if loc == nil {
+			break
+		}
+		start, end := pos+loc[2], pos+loc[3]
+
+		_, size := utf8.DecodeRune(line[start:])
+		if before, _ := utf8.DecodeLastRune(line[:start]); isWordRune(before) {
+			pos = start + max(size, 1)
+			continue
+		}
+
+		spans = append(spans, []int{start, end})
+		pos = end
+		if end == start {
+			pos += max(size, 1)
+		}
+	}
+	return spans
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// hasUppercase reports if the regular expression has uppercase
+// characters, other than those in escape sequences (like \W), for
+// smart case searches.
+func hasUppercase(pattern string) bool {
+	for idx := 0; idx < len(pattern); idx++ {
+		if pattern[idx] == '\\' {
+			idx++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(pattern[idx:])
+		if unicode.IsUpper(r) {
+			return true
+		}
+		idx += size - 1
+	}
+	return false
+}
+
+// ignoreRule is a pattern from an ignore file, which uses the syntax
+// of .gitignore files.
+type ignoreRule struct {
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func parseIgnoreRules(data string) []ignoreRule {
+	var rules []ignoreRule
+	for line := range strings.Lines(data) {
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " \t")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// like git, ignore invalid patterns.
+		if rule, ok := newIgnoreRule(line, false); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func newIgnoreRule(pattern string, fold bool) (ignoreRule, bool) {
+	rule := ignoreRule{}
+	if rule.negate = strings.HasPrefix(pattern, "!"); rule.negate {
+		pattern = pattern[1:]
+	}
+	if rule.dirOnly = strings.HasSuffix(pattern, "/"); rule.dirOnly {
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if rule.anchored = strings.Contains(pattern, "/"); rule.anchored {
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+
+	expr := "^" + globRegexp(pattern) + "$"
+	if fold {
+		expr = "(?i)" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+func (ir ignoreRule) match(rel string, isDir bool) bool {
+	switch {
+	case ir.dirOnly && !isDir:
+		return false
+	case ir.anchored:
+		return ir.re.MatchString(rel)
+	default:
+		return ir.re.MatchString(path.Base(rel))
+	}
+}
+
+// globRegexp converts a glob, in the syntax of .gitignore files, to
+// a regular expression. As with git, a "[" without a closing "]" is
+// a literal character.
+func globRegexp(glob string) string {
+	var buf strings.Builder
+	for idx := 0; idx < len(glob); idx++ {
+		switch rest := glob[idx:]; {
+		case strings.HasPrefix(rest, "**/"):
+			buf.WriteString("(?:.*/)?")
+			idx += 2
+		case rest == "/**":
+			buf.WriteString("/.*")
+			idx += 2
+		case strings.HasPrefix(rest, "**"):
+			buf.WriteString(".*")
+			idx++
+		case rest[0] == '*':
+			buf.WriteString("[^/]*")
+		case rest[0] == '?':
+			buf.WriteString("[^/]")
+		case rest[0] == '[' && len(rest) > 2 && strings.IndexByte(rest[2:], ']') >= 0:
+			end := 2 + strings.IndexByte(rest[2:], ']')
+			class := rest[1:end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			idx += end
+		case rest[0] == '\\' && len(rest) > 1:
+			buf.WriteString(regexp.QuoteMeta(rest[1:2]))
+			idx++
+		default:
+			buf.WriteString(regexp.QuoteMeta(rest[:1]))
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,367 @@
+package libfun
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+	"github.com/tychoish/jasper"
+)
+
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		assert.NotError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NotError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return root
+}
+
+func gzipString(t *testing.T, content string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(content))
+	assert.NotError(t, err)
+	assert.NotError(t, gz.Close())
+	return buf.String()
+}
+
+const ripgrepConformanceFixtures = "testdata/rg/conformance"
+
+// TestRipgrepConformance runs the same searches with each engine,
+// and checks that the native engine produces the same results as
+// ripgrep. The output of ripgrep is replayed from the fixtures in
+// testdata/rg/conformance; to record them again with the installed
+// ripgrep, after changing the tree or the searches, run the test with
+// LIBFUN_RECORD_RIPGREP=1.
+func TestRipgrepConformance(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go":           "package main\n\n// TODO: handle errors\nfunc main() {}\n",
+		"lib/util.go":       "package lib\n\nfunc todo() {} // TODOS\n",
+		"lib/util_test.go":  "package lib\n\n// TODO(tests)\n",
+		"docs/guide.md":     "# Guide\n\nTODO: write the guide\n",
+		"docs/notes.txt":    "nothing to see\n",
+		"scripts/build.sh":  "#!/bin/sh\necho TODO_LATER\n",
+		".hidden/secret.go": "// TODO\n",
+		".env":              "TODO=1\n",
+		".ignore":           "*.log\n!keep.log\n",
+		"debug.log":         "TODO\n",
+		"keep.log":          "TODO\n",
+		"data.bin":          "TODO\x00binary",
+		"archive.txt.gz":    gzipString(t, "TODO compressed\n"),
+		"extra.ignore":      "docs/\n/scripts\n",
+		"repo/.git/HEAD":    "ref: refs/heads/main\n",
+		"repo/.gitignore":   "ignored.go\n",
+		"repo/ignored.go":   "// TODO\n",
+		"repo/kept.go":      "// TODO\n",
+		"norepo/.gitignore": "ignored.go\n",
+		"norepo/ignored.go": "// TODO\n",
+	})
+
+	binary := WithExecutor(t.Context(), NewReplayExecutor(ripgrepConformanceFixtures))
+	if os.Getenv("LIBFUN_RECORD_RIPGREP") != "" {
+		if _, err := exec.LookPath("rg"); err != nil {
+			t.Fatal("recording requires ripgrep")
+		}
+		assert.NotError(t, os.RemoveAll(ripgrepConformanceFixtures))
+		binary = WithExecutor(t.Context(), NewRecordingExecutor(ripgrepConformanceFixtures))
+	}
+
+	jpm := jasper.NewManager(jasper.ManagerOptionSet(
+		jasper.ManagerOptions{
+			ID:           t.Name(),
+			Synchronized: true,
+			MaxProcs:     64,
+		}))
+
+	for _, tc := range []struct {
+		name string
+		args RipgrepArgs
+	}{
+		{
+			name: "Regexp",
+			args: RipgrepArgs{Regexp: "TODO"},
+		},
+		{
+			name: "WordRegexp",
+			args: RipgrepArgs{Regexp: "TODO", WordRegexp: true},
+		},
+		{
+			name: "Types",
+			args: RipgrepArgs{Regexp: "TODO", Types: []string{"go"}},
+		},
+		{
+			name: "ExcludedTypes",
+			args: RipgrepArgs{Regexp: "TODO", ExcludedTypes: []string{"go", "md"}},
+		},
+		{
+			name: "Invert",
+			args: RipgrepArgs{Regexp: "TODO", Types: []string{"go"}, Invert: true},
+		},
+		{
+			name: "IgnoreFile",
+			args: RipgrepArgs{Regexp: "TODO", IgnoreFile: "extra.ignore"},
+		},
+		{
+			name: "Zip",
+			args: RipgrepArgs{Regexp: "compressed", Zip: true},
+		},
+		{
+			name: "Directories",
+			args: RipgrepArgs{Regexp: "TODO", Types: []string{"go"}, Directories: true, Unique: true},
+		},
+		{
+			name: "NoMatches",
+			args: RipgrepArgs{Regexp: "libfun-no-matches"},
+		},
+		{
+			name: "Globs",
+			args: RipgrepArgs{Regexp: "TODO", Globs: []string{"*.md"}},
+		},
+		{
+			// globs take precedence over ignore files.
+			name: "ExcludedGlobs",
+			args: RipgrepArgs{Regexp: "TODO", Globs: []string{"*.go"}, ExcludedGlobs: []string{"lib/"}},
+		},
+		{
+			name: "GlobCaseInsensitive",
+			args: RipgrepArgs{Regexp: "TODO", Globs: []string{"*.MD"}, GlobCaseInsensitive: true},
+		},
+		{
+			name: "Hidden",
+			args: RipgrepArgs{Regexp: "TODO", Types: []string{"go"}, Hidden: true},
+		},
+		{
+			name: "MaxDepth",
+			args: RipgrepArgs{Regexp: "TODO", MaxDepth: 1},
+		},
+		{
+			name: "IgnoreCase",
+			args: RipgrepArgs{Regexp: "Todo", Types: []string{"go"}, IgnoreCase: true},
+		},
+		{
+			name: "SmartCase",
+			args: RipgrepArgs{Regexp: "todo", Types: []string{"go"}, SmartCase: true},
+		},
+		{
+			name: "SmartCaseUpper",
+			args: RipgrepArgs{Regexp: "TODOS", Types: []string{"go"}, SmartCase: true},
+		},
+		{
+			name: "FixedStrings",
+			args: RipgrepArgs{Regexp: "TODO(tests)", FixedStrings: true},
+		},
+		{
+			name: "MaxFilesize",
+			args: RipgrepArgs{Regexp: "TODO", MaxFilesize: 10},
+		},
+		{
+			name: "NoIgnore",
+			args: RipgrepArgs{Regexp: "TODO", NoIgnore: true},
+		},
+		{
+			name: "NoIgnoreVCS",
+			args: RipgrepArgs{Regexp: "TODO", NoIgnoreVCS: true},
+		},
+		{
+			name: "NoIgnoreDot",
+			args: RipgrepArgs{Regexp: "TODO", NoIgnoreDot: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := tc.args
+			args.Path = root
+
+			collect := func(ctx context.Context, engine RipgrepEngine) []string {
+				args.Engine = engine
+				seq, err := Ripgrep(ctx, jpm, args)
+				assert.NotError(t, err)
+				out := irt.Collect(seq)
+				slices.Sort(out)
+				return out
+			}
+
+			check.EqualItems(t, collect(t.Context(), RipgrepNative), collect(binary, RipgrepBinary))
+		})
+	}
+
+	t.Run("Matches", func(t *testing.T) {
+		root := writeTree(t, map[string]string{
+			"a.txt": "one\nTODO two\nthree\nTODO four\nfive\nsix\nseven\nTODO eight\r\n",
+		})
+
+		for _, maxCount := range []int{0, 2} {
+			t.Run(fmt.Sprint("MaxCount", maxCount), func(t *testing.T) {
+				collect := func(ctx context.Context, engine RipgrepEngine) []string {
+					var out []string
+					for m, err := range RipgrepMatches(ctx, jpm, RipgrepArgs{
+						Regexp:        "TODO",
+						Path:          root,
+						BeforeContext: 1,
+						AfterContext:  1,
+						MaxCount:      maxCount,
+						Engine:        engine,
+					}) {
+						assert.NotError(t, err)
+						out = append(out, fmt.Sprintf("%+v", m))
+					}
+					return out
+				}
+
+				want := collect(binary, RipgrepBinary)
+				check.NotEqual(t, len(want), 0)
+				check.EqualItems(t, collect(t.Context(), RipgrepNative), want)
+			})
+		}
+	})
+}
+
+func TestRipgrepNative(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go":    "package main\n\n// libfun-not-found\n",
+		"words.txt":  "foo foo.bar foobar _foo\n",
+		"nested.txt": "a/b/c\n",
+	})
+
+	t.Run("Auto", func(t *testing.T) {
+		// the replayed recording reports that rg is not
+		// installed.
+		ctx := WithExecutor(t.Context(), NewReplayExecutor("testdata/rg"))
+
+		_, err := Ripgrep(ctx, nil, RipgrepArgs{Regexp: "libfun-not-found", Path: root})
+		check.ErrorIs(t, err, ErrCommandNotFound)
+
+		seq, err := Ripgrep(ctx, nil, RipgrepArgs{Regexp: "libfun-not-found", Path: root, Engine: RipgrepAuto})
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(seq), []string{filepath.Join(root, "main.go")})
+
+		var lines []int
+		for m, err := range RipgrepMatches(ctx, nil, RipgrepArgs{Regexp: "libfun-not-found", Path: root, Engine: RipgrepAuto}) {
+			assert.NotError(t, err)
+			lines = append(lines, m.Line)
+		}
+		check.EqualItems(t, lines, []int{3})
+	})
+	t.Run("WordSubmatches", func(t *testing.T) {
+		var spans []RipgrepSubmatch
+		for m, err := range RipgrepMatches(t.Context(), nil, RipgrepArgs{Regexp: "foo", Path: root, WordRegexp: true, Engine: RipgrepNative}) {
+			assert.NotError(t, err)
+			spans = append(spans, m.Submatches...)
+		}
+		check.EqualItems(t, spans, []RipgrepSubmatch{{Text: "foo", Start: 0, End: 3}, {Text: "foo", Start: 4, End: 7}})
+	})
+	t.Run("WordOverlap", func(t *testing.T) {
+		// the first candidate of each line is not a word, but
+		// overlapping and longer matches are.
+		root := writeTree(t, map[string]string{"a.txt": "xa b a b\nfoobar\n"})
+
+		var spans []RipgrepSubmatch
+		for m, err := range RipgrepMatches(t.Context(), nil, RipgrepArgs{Regexp: "a b|b|foo|foobar", Path: root, WordRegexp: true, Engine: RipgrepNative}) {
+			assert.NotError(t, err)
+			spans = append(spans, m.Submatches...)
+		}
+		check.EqualItems(t, spans, []RipgrepSubmatch{{Text: "b", Start: 3, End: 4}, {Text: "a b", Start: 5, End: 8}, {Text: "foobar", Start: 0, End: 6}})
+	})
+	t.Run("EarlyReturn", func(t *testing.T) {
+		count := 0
+		for _, err := range RipgrepMatches(t.Context(), nil, RipgrepArgs{Regexp: ".", Path: root, Engine: RipgrepNative}) {
+			assert.NotError(t, err)
+			count++
+			break
+		}
+		check.Equal(t, count, 1)
+	})
+	t.Run("Errors", func(t *testing.T) {
+		_, err := Ripgrep(t.Context(), nil, RipgrepArgs{Regexp: "TODO", Path: root, Types: []string{"cobol"}, Engine: RipgrepNative})
+		check.ErrorIs(t, err, ErrUnknownFileType)
+
+		_, err = Ripgrep(t.Context(), nil, RipgrepArgs{Regexp: "TODO(", Path: root, Engine: RipgrepNative})
+		check.Error(t, err)
+
+		_, err = Ripgrep(t.Context(), nil, RipgrepArgs{Regexp: "TODO", Path: root, Follow: true, Engine: RipgrepNative})
+		check.ErrorIs(t, err, ErrNativeUnsupported)
+		_, err = Ripgrep(t.Context(), nil, RipgrepArgs{Regexp: "TODO", Path: root, Multiline: true, Engine: RipgrepNative})
+		check.ErrorIs(t, err, ErrNativeUnsupported)
+
+		_, err = Ripgrep(t.Context(), nil, RipgrepArgs{Regexp: "TODO", Path: filepath.Join(root, "missing"), Engine: RipgrepNative})
+		check.ErrorIs(t, err, os.ErrNotExist)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		_, err = Ripgrep(ctx, nil, RipgrepArgs{Regexp: "TODO", Path: root, Engine: RipgrepNative})
+		check.Error(t, err)
+	})
+	t.Run("Unreadable", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("permissions do not apply to root")
+		}
+
+		root := writeTree(t, map[string]string{"a.txt": "TODO\n", "locked/b.txt": "TODO\n", "c.txt": "TODO\n"})
+		assert.NotError(t, os.Chmod(filepath.Join(root, "c.txt"), 0))
+		assert.NotError(t, os.Chmod(filepath.Join(root, "locked"), 0))
+		t.Cleanup(func() { _ = os.Chmod(filepath.Join(root, "locked"), 0o755) })
+
+		// unreadable files and directories are skipped, but an
+		// unreadable root is an error.
+		seq, err := Ripgrep(t.Context(), nil, RipgrepArgs{Regexp: "TODO", Path: root, Engine: RipgrepNative})
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(seq), []string{filepath.Join(root, "a.txt")})
+
+		_, err = Ripgrep(t.Context(), nil, RipgrepArgs{Regexp: "TODO", Path: filepath.Join(root, "locked"), Engine: RipgrepNative})
+		check.ErrorIs(t, err, os.ErrPermission)
+	})
+	t.Run("UnclosedClass", func(t *testing.T) {
+		root := writeTree(t, map[string]string{".ignore": "foo[\n", "foo[": "TODO\n", "a[": "TODO\n", "bar": "TODO\n"})
+
+		seq, err := Ripgrep(t.Context(), nil, RipgrepArgs{Regexp: "TODO", Path: root, Engine: RipgrepNative})
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(seq), []string{filepath.Join(root, "a["), filepath.Join(root, "bar")})
+
+		seq, err = Ripgrep(t.Context(), nil, RipgrepArgs{Regexp: "TODO", Path: root, Globs: []string{"a["}, Engine: RipgrepNative})
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(seq), []string{filepath.Join(root, "a[")})
+	})
+	t.Run("IgnoreRules", func(t *testing.T) {
+		for _, tc := range []struct {
+			pattern string
+			path    string
+			isDir   bool
+			want    bool
+		}{
+			{pattern: "*.log", path: "a/b/debug.log", want: true},
+			{pattern: "/build", path: "build", isDir: true, want: true},
+			{pattern: "/build", path: "src/build", isDir: true, want: false},
+			{pattern: "build/", path: "src/build", isDir: false, want: false},
+			{pattern: "build/", path: "src/build", isDir: true, want: true},
+			{pattern: "docs/**/*.md", path: "docs/a/b/x.md", want: true},
+			{pattern: "docs/**/*.md", path: "docs/x.md", want: true},
+			{pattern: "**/gen", path: "a/gen", isDir: true, want: true},
+			{pattern: "file[0-9].txt", path: "file3.txt", want: true},
+			{pattern: "file[!0-9].txt", path: "file3.txt", want: false},
+			{pattern: `\#notes`, path: "#notes", want: true},
+			{pattern: "?.go", path: "ab.go", want: false},
+			{pattern: "foo[", path: "foo[", want: true},
+			{pattern: "[", path: "[", want: true},
+		} {
+			rules := parseIgnoreRules(tc.pattern + "\n")
+			assert.Equal(t, len(rules), 1)
+			check.Equal(t, rules[0].match(tc.path, tc.isDir), tc.want)
+		}
+
+		rules := parseIgnoreRules("# comment\n\n*.log\n!keep.log\n")
+		assert.Equal(t, len(rules), 2)
+		check.True(t, rules[1].negate)
+	})
+}
@@ -0,0 +1,129 @@
+package libfun
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+)
+
+// RotatingFile is an io.WriteCloser that appends to a file, and
+// rotates the file when it would exceed a maximum size: the current
+// file is renamed to "<path>.1", the previous "<path>.1" to
+// "<path>.2", and so on, retaining a limited number of previous
+// files. RotatingFiles are safe for concurrent use, and are suitable
+// as the Stdout and Stderr of a CommandTee.
+type RotatingFile struct {
+	path     string
+	maxBytes int64
+	keep     int
+
+	mtx  sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens (or creates) the file at the path for
+// appending. Writes that would grow the file beyond maxBytes rotate
+// the file first, unless the file is empty, and at most keep
+// previous files are retained. When maxBytes is not positive, the
+// file never rotates.
+func OpenRotatingFile(path string, maxBytes int64, keep int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: TryExpandHomedir(path), maxBytes: maxBytes, keep: max(keep, 0)}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return ers.Wrapf(err, "opening %q", rf.path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return erc.Join(ers.Wrapf(err, "opening %q", rf.path), file.Close())
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+// Path returns the path of the current file.
+func (rf *RotatingFile) Path() string { return rf.path }
+
+func (rf *RotatingFile) Write(in []byte) (int, error) {
+	rf.mtx.Lock()
+	defer rf.mtx.Unlock()
+
+	if rf.file == nil {
+		return 0, ers.Wrapf(fs.ErrClosed, "writing %q", rf.path)
+	}
+
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(in)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(in)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file, regardless of its size.
+func (rf *RotatingFile) Rotate() error {
+	rf.mtx.Lock()
+	defer rf.mtx.Unlock()
+
+	if rf.file == nil {
+		return ers.Wrapf(fs.ErrClosed, "rotating %q", rf.path)
+	}
+	return rf.rotate()
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return ers.Wrapf(err, "rotating %q", rf.path)
+	}
+	rf.file = nil
+
+	name := func(idx int) string { return fmt.Sprintf("%s.%d", rf.path, idx) }
+
+	ec := &erc.Collector{}
+	if rf.keep == 0 {
+		ec.Push(ignoreNotExist(os.Remove(rf.path)))
+	} else {
+		ec.Push(ignoreNotExist(os.Remove(name(rf.keep))))
+		for idx := rf.keep - 1; idx > 0; idx-- {
+			ec.Push(ignoreNotExist(os.Rename(name(idx), name(idx+1))))
+		}
+		ec.Push(os.Rename(rf.path, name(1)))
+	}
+
+	if err := ec.Resolve(); err != nil {
+		// reopen the current file so that writes can
+		// continue, even though rotation failed.
+		return erc.Join(ers.Wrapf(err, "rotating %q", rf.path), rf.open())
+	}
+
+	return rf.open()
+}
+
+// Close closes the current file.
+func (rf *RotatingFile) Close() error {
+	rf.mtx.Lock()
+	defer rf.mtx.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
@@ -0,0 +1,101 @@
+package libfun
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+)
+
+func TestRotatingFile(t *testing.T) {
+	read := func(t *testing.T, path string) string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		assert.NotError(t, err)
+		return string(data)
+	}
+
+	t.Run("Rotation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.log")
+		rf, err := OpenRotatingFile(path, 8, 2)
+		assert.NotError(t, err)
+		defer rf.Close()
+
+		for _, line := range []string{"aaaa\n", "bbb\n", "cccc\n", "dddd\n", "e\n"} {
+			_, err := rf.Write([]byte(line))
+			assert.NotError(t, err)
+		}
+
+		check.Equal(t, read(t, path), "dddd\ne\n")
+		check.Equal(t, read(t, path+".1"), "cccc\n")
+		check.Equal(t, read(t, path+".2"), "bbb\n")
+		_, err = os.Stat(path + ".3")
+		check.ErrorIs(t, err, fs.ErrNotExist)
+	})
+	t.Run("Append", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.log")
+		assert.NotError(t, os.WriteFile(path, []byte("existing\n"), 0o644))
+
+		rf, err := OpenRotatingFile(path, 12, 1)
+		assert.NotError(t, err)
+		_, err = rf.Write([]byte("new\n"))
+		assert.NotError(t, err)
+		assert.NotError(t, rf.Close())
+
+		check.Equal(t, read(t, path), "new\n")
+		check.Equal(t, read(t, path+".1"), "existing\n")
+	})
+	t.Run("Oversized", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.log")
+		rf, err := OpenRotatingFile(path, 4, 1)
+		assert.NotError(t, err)
+		defer rf.Close()
+
+		// writes larger than the limit are not split
+		_, err = rf.Write([]byte("0123456789\n"))
+		assert.NotError(t, err)
+		check.Equal(t, read(t, path), "0123456789\n")
+	})
+	t.Run("NoKeep", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.log")
+		rf, err := OpenRotatingFile(path, 4, 0)
+		assert.NotError(t, err)
+		defer rf.Close()
+
+		_, _ = rf.Write([]byte("one\n"))
+		_, _ = rf.Write([]byte("two\n"))
+		check.Equal(t, read(t, path), "two\n")
+		_, err = os.Stat(path + ".1")
+		check.ErrorIs(t, err, fs.ErrNotExist)
+	})
+	t.Run("Closed", func(t *testing.T) {
+		rf, err := OpenRotatingFile(filepath.Join(t.TempDir(), "out.log"), 0, 0)
+		assert.NotError(t, err)
+		assert.NotError(t, rf.Close())
+		assert.NotError(t, rf.Close())
+		_, err = rf.Write([]byte("x"))
+		check.ErrorIs(t, err, fs.ErrClosed)
+		check.ErrorIs(t, rf.Rotate(), fs.ErrClosed)
+	})
+	t.Run("Tee", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cmd.log")
+		rf, err := OpenRotatingFile(path, 1024, 1)
+		assert.NotError(t, err)
+		defer rf.Close()
+
+		cmd := MakeCommand("sh", "-c", "echo out; echo err >&2")
+		cmd.Tee = &CommandTee{Stdout: rf, Stderr: rf}
+		assert.NotError(t, cmd.Run(testContext(t)))
+
+		content := read(t, path)
+		check.Substring(t, content, "out\n")
+		check.Substring(t, content, "err\n")
+
+		assert.NotError(t, rf.Rotate())
+		check.Equal(t, read(t, path), "")
+		check.Equal(t, read(t, path+".1"), content)
+	})
+}
@@ -0,0 +1,138 @@
+package libfun
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+)
+
+// ScriptOptions control how RunScript runs a script. The zero value
+// runs scripts with bash in strict mode.
+type ScriptOptions struct {
+	// Shell is the program that runs the script, and defaults to
+	// bash.
+	Shell string
+	// Args are the positional arguments of the script ($1, $2,
+	// and so on).
+	Args []string
+	// Env, Dir, Stdin, and Timeout are the same as the fields of
+	// Command.
+	Env     map[string]string
+	Dir     string
+	Stdin   io.Reader
+	Timeout time.Duration
+	// Lax disables strict mode. In strict mode, scripts exit when
+	// a command fails (set -e), when they use an unset variable
+	// (set -u), and, for shells that support it, when any command
+	// in a pipeline fails (set -o pipefail). Scripts run by bash in
+	// strict mode also report the line of the command that
+	// failed.
+	Lax bool
+}
+
+func (opts ScriptOptions) shell() string {
+	if opts.Shell == "" {
+		return "bash"
+	}
+	return opts.Shell
+}
+
+func (opts ScriptOptions) isBash() bool { return filepath.Base(opts.shell()) == "bash" }
+
+func (opts ScriptOptions) flags() []string {
+	switch {
+	case opts.Lax:
+		return nil
+	case opts.isBash():
+		// -E makes functions and subshells inherit the ERR
+		// trap.
+		return []string{"-e", "-u", "-E", "-o", "pipefail"}
+	default:
+		switch filepath.Base(opts.shell()) {
+		case "zsh", "ksh", "mksh":
+			return []string{"-e", "-u", "-o", "pipefail"}
+		}
+		return []string{"-e", "-u"}
+	}
+}
+
+// source returns the content of the script file. In strict mode,
+// bash scripts are prefixed with an ERR trap that reports the line
+// of failing commands in the same format as bash's own errors. The
+// trap is on the same line as the start of the script, so that the
+// lines of the file and the script are the same, and its output is
+// removed from the Err of the ErrOutput.
+func (opts ScriptOptions) source(script string) string {
+	if !opts.trapped() {
+		return script
+	}
+	return `trap 'echo "$0: line $LINENO: exit status $?" >&2' ERR; ` + script
+}
+
+func (opts ScriptOptions) trapped() bool { return !opts.Lax && opts.isBash() }
+
+// RunScript writes the script, which may span many lines, to a
+// temporary file, runs it with the shell, and returns an iterator
+// over the lines of its standard output. If the script fails, the
+// error is an *ErrOutput, and when the shell reports the line of the
+// script that failed (e.g. "script: line 3: ..."), the Line of the
+// ErrOutput is that line.
+func RunScript(ctx context.Context, script string, opts ScriptOptions) (iter.Seq[string], error) {
+	file, err := os.CreateTemp("", "libfun-script-*.sh")
+	if err != nil {
+		return nil, ers.Wrap(err, "creating script file")
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	_, err = io.WriteString(file, opts.source(script))
+	if err = erc.Join(err, file.Close()); err != nil {
+		return nil, ers.Wrap(err, "writing script file")
+	}
+
+	args := append([]string{opts.shell()}, opts.flags()...)
+	args = append(append(args, file.Name()), opts.Args...)
+
+	c := MakeCommand(args...)
+	c.Env = opts.Env
+	c.Dir = opts.Dir
+	c.Stdin = opts.Stdin
+	c.Timeout = opts.Timeout
+
+	out, err := c.Lines(ctx)
+	if eo := (*ErrOutput)(nil); errors.As(err, &eo) {
+		eo.Line = scriptLine(file.Name(), eo.Err)
+		// the output of the trap is not part of the script's
+		// standard error.
+		if opts.trapped() {
+			eo.Err = scriptTrapOutput(file.Name()).ReplaceAllString(eo.Err, "")
+		}
+	}
+
+	return out, err
+}
+
+func scriptTrapOutput(path string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(path) + `: line [0-9]+: exit status [0-9]+\n?`)
+}
+
+// scriptLine returns the line in the last error that the shell
+// reported for the script, in either bash's ("script: line 3: ...")
+// or dash's ("script: 3: ...") format, or 0 if there is none.
+func scriptLine(path, stderr string) int {
+	re := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(path) + `: (?:line )?([0-9]+): `)
+	matches := re.FindAllStringSubmatch(stderr, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	line, _ := strconv.Atoi(matches[len(matches)-1][1])
+	return line
+}
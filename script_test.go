@@ -0,0 +1,100 @@
+package libfun
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+)
+
+func TestRunScript(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	t.Run("Output", func(t *testing.T) {
+		out, err := RunScript(testContext(t), `
+for i in 1 2 3; do
+	echo "line $i"
+done
+`, ScriptOptions{})
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(out), []string{"line 1", "line 2", "line 3"})
+	})
+	t.Run("ArgsAndEnv", func(t *testing.T) {
+		out, err := RunScript(testContext(t), `echo "$#: $1|$2|$GREETING"`, ScriptOptions{
+			Args: []string{"a b", "c"},
+			Env:  map[string]string{"GREETING": "hello"},
+		})
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(out), []string{"2: a b|c|hello"})
+	})
+	t.Run("Dir", func(t *testing.T) {
+		dir := t.TempDir()
+		out, err := RunScript(testContext(t), "pwd", ScriptOptions{Dir: dir})
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(out), []string{dir})
+	})
+	t.Run("Stdin", func(t *testing.T) {
+		out, err := RunScript(testContext(t), "tr a-z A-Z", ScriptOptions{Stdin: strings.NewReader("abc\n")})
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(out), []string{"ABC"})
+	})
+	t.Run("Errexit", func(t *testing.T) {
+		_, err := RunScript(testContext(t), "echo one\nfalse\necho two\n", ScriptOptions{})
+		check.ErrorIs(t, err, ErrNonZeroExit)
+		eo := errOutput(t, err)
+		check.Equal(t, eo.Line, 2)
+		check.Equal(t, eo.Out, "one\n")
+		check.Equal(t, eo.Err, "")
+		check.Substring(t, eo.Error(), "line: 2")
+	})
+	t.Run("Function", func(t *testing.T) {
+		_, err := RunScript(testContext(t), "fail() {\n\treturn 3\n}\necho ok\nfail\n", ScriptOptions{})
+		eo := errOutput(t, err)
+		check.Equal(t, eo.ExitCode, 3)
+		check.True(t, eo.Line > 0)
+		check.Equal(t, eo.Err, "")
+
+		_, err = RunScript(testContext(t), "echo problem >&2\nexit 4\n", ScriptOptions{})
+		check.Equal(t, errOutput(t, err).Err, "problem\n")
+	})
+	t.Run("Unset", func(t *testing.T) {
+		_, err := RunScript(testContext(t), "#!/bin/bash\n\necho $LIBFUN_UNSET_VARIABLE\n", ScriptOptions{})
+		eo := errOutput(t, err)
+		check.Equal(t, eo.Line, 3)
+		check.Substring(t, eo.Err, "unbound variable")
+		check.NotSubstring(t, eo.Err, "exit status")
+	})
+	t.Run("Pipefail", func(t *testing.T) {
+		script := "false | cat\necho after\n"
+		_, err := RunScript(testContext(t), script, ScriptOptions{})
+		check.Equal(t, errOutput(t, err).Line, 1)
+
+		out, err := RunScript(testContext(t), script, ScriptOptions{Lax: true})
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(out), []string{"after"})
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := RunScript(testContext(t), "\n\nlibfun-command-does-not-exist\n", ScriptOptions{Lax: true})
+		eo := errOutput(t, err)
+		check.Equal(t, eo.ExitCode, 127)
+		check.Equal(t, eo.Line, 3)
+	})
+	t.Run("Sh", func(t *testing.T) {
+		_, err := RunScript(testContext(t), "echo $1\necho $2\n", ScriptOptions{Shell: "sh", Args: []string{"one"}})
+		eo := errOutput(t, err)
+		check.Equal(t, eo.Out, "one\n")
+		check.Equal(t, eo.Line, 2)
+	})
+	t.Run("Line", func(t *testing.T) {
+		path := "/tmp/libfun-script-1.sh"
+		check.Equal(t, scriptLine(path, path+": line 12: foo: command not found\n"), 12)
+		check.Equal(t, scriptLine(path, path+": 4: foo: not found\n"), 4)
+		check.Equal(t, scriptLine(path, path+": line 1: x\n"+path+": line 7: exit status 1\n"), 7)
+		check.Equal(t, scriptLine(path, "other: line 3: x\n"), 0)
+	})
+}
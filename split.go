@@ -0,0 +1,139 @@
+package libfun
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"iter"
+	"regexp"
+
+	"github.com/tychoish/fun/ers"
+	"github.com/tychoish/fun/irt"
+)
+
+// The split functions in this file divide the output of commands
+// into records, and are used with Command.Split, RunCommandSplit,
+// and RunCommandWithInputSplit. They are bufio.SplitFuncs, so the
+// split functions in bufio (e.g. bufio.ScanWords) are also valid.
+
+// ScanNull splits output into records terminated by NUL bytes, as
+// produced by `find -print0`, `xargs -0`, `git -z`, and `rg --null`.
+func ScanNull(data []byte, atEOF bool) (int, []byte, error) { return ScanDelimiter(0)(data, atEOF) }
+
+// ScanDelimiter returns a split function that splits output into
+// records terminated by the delimiter. The delimiter is not included
+// in the records, and the final record need not be terminated.
+func ScanDelimiter(delim byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if idx := bytes.IndexByte(data, delim); idx >= 0 {
+			return idx + 1, data[:idx], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// ScanFixed returns a split function that splits output into records
+// of size bytes. The final record is shorter than size when the
+// length of the output is not a multiple of size.
+func ScanFixed(size int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		switch {
+		case size <= 0:
+			return 0, nil, ers.Wrapf(ErrUndefinedOperation, "record size %d", size)
+		case len(data) >= size:
+			return size, data[:size], nil
+		case atEOF && len(data) > 0:
+			return len(data), data, nil
+		default:
+			return 0, nil, nil
+		}
+	}
+}
+
+// ScanRegexp returns a split function that splits output into
+// records separated by matches of the expression. The separators are
+// not included in the records, and empty matches are ignored.
+func ScanRegexp(re *regexp.Regexp) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+
+		for _, loc := range re.FindAllIndex(data, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			// a match at the end of the buffer may continue
+			// in data that has not been read yet.
+			if loc[1] == len(data) && !atEOF {
+				return 0, nil, nil
+			}
+			return loc[1], data[:loc[0]], nil
+		}
+
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// ScanChunks does not split output, and produces the output in the
+// chunks in which it is read. With Command.Chunks and Stream, chunks
+// are produced as soon as the process writes them.
+func ScanChunks(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	return len(data), data, nil
+}
+
+// maxRecordSize is the largest record that scan produces from
+// streamed output: longer records are an error.
+const maxRecordSize = 64 * 1024 * 1024
+
+// scan divides the contents of the reader into records, using
+// bufio.ScanLines when split is nil. The records are only valid
+// until the iteration continues. Any error from the reader (or
+// split function) is the final element of the sequence.
+func scan(r io.Reader, split bufio.SplitFunc) iter.Seq2[[]byte, error] {
+	return scanSize(r, split, maxRecordSize)
+}
+
+func scanSize(r io.Reader, split bufio.SplitFunc, size int) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, size)
+		if split != nil {
+			scanner.Split(split)
+		}
+		for scanner.Scan() {
+			if !yield(scanner.Bytes(), nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// scanStrings splits output that is already in memory into records,
+// and returns an error (rather than a truncated sequence) if the
+// split function fails. No record can be longer than the output.
+func scanStrings(out []byte, split bufio.SplitFunc) (iter.Seq[string], error) {
+	var records []string
+	for rec, err := range scanSize(bytes.NewReader(out), split, len(out)+1) {
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, string(rec))
+	}
+	return irt.Slice(records), nil
+}
@@ -0,0 +1,125 @@
+package libfun
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/irt"
+)
+
+func TestSplit(t *testing.T) {
+	records := func(t *testing.T, input string, split bufio.SplitFunc) []string {
+		t.Helper()
+		// reading one byte at a time exercises records that span
+		// reads.
+		out, err := erc.FromIteratorAll(irt.Convert2(
+			scan(iotest.OneByteReader(strings.NewReader(input)), split),
+			func(rec []byte, err error) (string, error) { return string(rec), err },
+		))
+		assert.NotError(t, err)
+		return out
+	}
+
+	t.Run("Lines", func(t *testing.T) {
+		check.EqualItems(t, records(t, "a\r\nb\nc", nil), []string{"a", "b", "c"})
+	})
+	t.Run("Null", func(t *testing.T) {
+		check.EqualItems(t, records(t, "a\nb\x00c\x00", ScanNull), []string{"a\nb", "c"})
+		check.EqualItems(t, records(t, "a\x00\x00c", ScanNull), []string{"a", "", "c"})
+		check.Equal(t, len(records(t, "", ScanNull)), 0)
+	})
+	t.Run("Delimiter", func(t *testing.T) {
+		check.EqualItems(t, records(t, "a,b,,c,", ScanDelimiter(',')), []string{"a", "b", "", "c"})
+	})
+	t.Run("Fixed", func(t *testing.T) {
+		check.EqualItems(t, records(t, "aaabbbcc", ScanFixed(3)), []string{"aaa", "bbb", "cc"})
+
+		_, err := erc.FromIteratorAll(scan(strings.NewReader("abc"), ScanFixed(0)))
+		check.ErrorIs(t, err, ErrUndefinedOperation)
+
+		_, err = scanStrings([]byte("abc"), ScanFixed(0))
+		check.ErrorIs(t, err, ErrUndefinedOperation)
+	})
+	t.Run("TooLong", func(t *testing.T) {
+		_, err := erc.FromIteratorAll(scanSize(strings.NewReader(strings.Repeat("a", 100)), nil, 64))
+		check.ErrorIs(t, err, bufio.ErrTooLong)
+	})
+	t.Run("Regexp", func(t *testing.T) {
+		re := regexp.MustCompile(`\n-{3,}\n`)
+		check.EqualItems(t, records(t, "one\n---\ntwo\n-----\nthree", ScanRegexp(re)), []string{"one", "two", "three"})
+		check.EqualItems(t, records(t, "a  b c", ScanRegexp(regexp.MustCompile(` *`))), []string{"a", "b", "c"})
+	})
+	t.Run("Chunks", func(t *testing.T) {
+		out := records(t, "abc\x00def\n", ScanChunks)
+		check.Equal(t, strings.Join(out, ""), "abc\x00def\n")
+		check.Equal(t, len(out), 8)
+	})
+}
+
+func TestCommandSplit(t *testing.T) {
+	t.Run("RunCommand", func(t *testing.T) {
+		seq, err := RunCommandSplit(testContext(t), `printf 'a\nb\0c\0'`, ScanNull)
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"a\nb", "c"})
+	})
+	t.Run("Input", func(t *testing.T) {
+		seq, err := RunCommandWithInputSplit(testContext(t), "cat", strings.NewReader("a;b;c"), ScanDelimiter(';'))
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(seq), []string{"a", "b", "c"})
+	})
+	t.Run("Stream", func(t *testing.T) {
+		cmd := MakeCommand("printf", `x\0y\0`)
+		cmd.Split = ScanNull
+		out, err := erc.FromIteratorAll(cmd.Stream(testContext(t)))
+		assert.NotError(t, err)
+		assert.EqualItems(t, out, []string{"x", "y"})
+	})
+	t.Run("Chunks", func(t *testing.T) {
+		var buf bytes.Buffer
+		for chunk, err := range MakeCommand("head", "-c", "100000", "/dev/zero").Chunks(testContext(t)) {
+			assert.NotError(t, err)
+			buf.Write(chunk)
+		}
+		check.Equal(t, buf.Len(), 100000)
+	})
+	t.Run("Result", func(t *testing.T) {
+		cmd := MakeCommand("printf", `x\0y\0`)
+		cmd.Split = ScanNull
+		res := cmd.Result(testContext(t))
+		assert.NotError(t, res.Err)
+		lines, err := res.Lines()
+		assert.NotError(t, err)
+		assert.EqualItems(t, irt.Collect(lines), []string{"x", "y"})
+
+		res.Command.Split = ScanFixed(0)
+		_, err = res.Lines()
+		check.ErrorIs(t, err, ErrUndefinedOperation)
+	})
+	t.Run("LongLines", func(t *testing.T) {
+		cmd := MakeCommand("sh", "-c", `head -c 70000 /dev/zero | tr '\0' a; echo; echo end`)
+
+		lines, err := cmd.Lines(testContext(t))
+		assert.NotError(t, err)
+		out := irt.Collect(lines)
+		assert.Equal(t, len(out), 2)
+		check.Equal(t, out[0], strings.Repeat("a", 70000))
+		check.Equal(t, out[1], "end")
+
+		count := 0
+		for line, err := range cmd.Stream(testContext(t)) {
+			assert.NotError(t, err)
+			count++
+			if count == 1 {
+				check.Equal(t, len(line), 70000)
+			}
+		}
+		check.Equal(t, count, 2)
+	})
+}
@@ -0,0 +1,395 @@
+package libfun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+	"github.com/tychoish/grip"
+	"github.com/tychoish/grip/message"
+)
+
+// ErrSupervisorExists is returned by SupervisorGroup.Start when the
+// group already has a running process with the same name.
+const ErrSupervisorExists ers.Error = "supervised process already exists"
+
+// ErrSupervisorFailed is returned by SupervisedProcess.Wait when the
+// process exceeded MaxRestarts.
+const ErrSupervisorFailed ers.Error = "supervised process failed"
+
+// SupervisorState describes the lifecycle of a supervised process.
+type SupervisorState int
+
+const (
+	// SupervisorStarting indicates that the supervisor has not
+	// yet started the process.
+	SupervisorStarting SupervisorState = iota
+	// SupervisorRunning indicates that the process is running.
+	SupervisorRunning
+	// SupervisorBackoff indicates that the process exited and
+	// will restart after a delay.
+	SupervisorBackoff
+	// SupervisorStopped indicates that the supervisor was stopped
+	// by its context or by Stop.
+	SupervisorStopped
+	// SupervisorFailed indicates that the process exited more
+	// than MaxRestarts times, and will not restart.
+	SupervisorFailed
+)
+
+func (s SupervisorState) String() string {
+	switch s {
+	case SupervisorStarting:
+		return "starting"
+	case SupervisorRunning:
+		return "running"
+	case SupervisorBackoff:
+		return "backoff"
+	case SupervisorStopped:
+		return "stopped"
+	case SupervisorFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("SupervisorState<%d>", int(s))
+	}
+}
+
+// Supervisor describes a long-running command, such as a tunnel,
+// file watcher, or sync daemon, that is restarted whenever it exits,
+// until the supervisor is stopped.
+type Supervisor struct {
+	// Name identifies the process in logs and in a
+	// SupervisorGroup.
+	Name    string
+	Command Command
+	// Delay is the time to wait before the first restart
+	// (defaulting to one second), and doubles after each
+	// consecutive restart, up to MaxDelay when MaxDelay is
+	// positive. Jitter, between 0 and 1, is
+	// the fraction of each delay that is randomized.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	Jitter   float64
+	// ResetAfter, when positive, resets the delay to Delay when
+	// a process runs for at least this long before exiting.
+	ResetAfter time.Duration
+	// MaxRestarts, when positive, limits the number of restarts,
+	// after which the supervisor fails.
+	MaxRestarts int
+}
+
+// SupervisorStatus is a snapshot of the state of a supervised
+// process.
+type SupervisorStatus struct {
+	Name     string
+	State    SupervisorState
+	Restarts int
+	// StartedAt is the time that the current (or most recent)
+	// process started.
+	StartedAt time.Time
+	// LastExit is the time that the most recent process exited,
+	// and LastError describes how it exited: it is typically an
+	// *ErrOutput, or nil when the process exited successfully.
+	LastExit  time.Time
+	LastError error
+}
+
+// SupervisedProcess is a handle for a process started by a
+// Supervisor.
+type SupervisedProcess struct {
+	spec   Supervisor
+	output *tailBuffer
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mtx     sync.Mutex
+	status  SupervisorStatus
+	restart context.CancelFunc
+	err     error
+}
+
+// Start runs the command in a background goroutine, and restarts it
+// whenever it exits, until the context is canceled, Stop is called,
+// or the process exceeds MaxRestarts. The command's standard output
+// and standard error are combined, and the end of the output is
+// available from the handle's Output method.
+func (s Supervisor) Start(ctx context.Context) *SupervisedProcess {
+	ctx, cancel := context.WithCancel(ctx)
+	sp := &SupervisedProcess{
+		spec:   s,
+		output: &tailBuffer{size: MaxErrOutputSize},
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: SupervisorStatus{Name: s.Name},
+	}
+
+	go sp.supervise(ctx)
+
+	return sp
+}
+
+// Status returns a snapshot of the status of the process.
+func (sp *SupervisedProcess) Status() SupervisorStatus {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	return sp.status
+}
+
+// Output returns the end of the combined output of the process, up to
+// MaxErrOutputSize bytes, including output from previous runs.
+func (sp *SupervisedProcess) Output() string { return sp.output.String() }
+
+// Restart terminates the current process, which then restarts
+// immediately, without waiting for the restart delay. Restart has no
+// effect before the process starts.
+func (sp *SupervisedProcess) Restart() {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	if sp.restart != nil {
+		sp.restart()
+	}
+}
+
+// Stop terminates the process, and waits for the supervisor to
+// exit. Canceling the context passed to Start has the same effect.
+func (sp *SupervisedProcess) Stop() error { sp.cancel(); return sp.Wait() }
+
+// Done returns a channel that is closed when the supervisor exits.
+func (sp *SupervisedProcess) Done() <-chan struct{} { return sp.done }
+
+// Wait blocks until the supervisor exits, and returns an error,
+// matching ErrSupervisorFailed, if the process exceeded MaxRestarts.
+func (sp *SupervisedProcess) Wait() error {
+	<-sp.done
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	return sp.err
+}
+
+func (sp *SupervisedProcess) update(op func(*SupervisorStatus)) {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	op(&sp.status)
+}
+
+func (sp *SupervisedProcess) supervise(ctx context.Context) {
+	defer close(sp.done)
+	defer sp.cancel()
+
+	backoff := RetryPolicy{Delay: sp.spec.Delay, MaxDelay: sp.spec.MaxDelay, Jitter: sp.spec.Jitter}
+	if backoff.Delay <= 0 {
+		backoff.Delay = time.Second
+	}
+	logger := grip.Context(ctx)
+	// logs are redacted in the same way as audit records.
+	redaction := sp.spec.Command.redaction(ctx)
+	cmd := redaction.redactCommand(sp.spec.Command.Args)
+
+	for consecutive := 1; ; consecutive++ {
+		runCtx, restart := context.WithCancel(ctx)
+		sp.mtx.Lock()
+		sp.restart = restart
+		sp.status.State = SupervisorRunning
+		sp.status.StartedAt = time.Now()
+		sp.mtx.Unlock()
+
+		// errors only report the output of the current run
+		stdout := &tailBuffer{size: MaxErrOutputSize}
+		stderr := &tailBuffer{size: MaxErrOutputSize}
+		err := sp.spec.Command.exec(runCtx, io.MultiWriter(sp.output, stdout), io.MultiWriter(sp.output, stderr))
+		restarted := runCtx.Err() != nil && ctx.Err() == nil
+		restart()
+
+		if err != nil {
+			err = newErrOutput(sp.spec.Command.String(), err, stdout.String(), stderr.String())
+		}
+
+		var uptime time.Duration
+		sp.update(func(st *SupervisorStatus) {
+			st.LastExit = time.Now()
+			st.LastError = err
+			uptime = st.LastExit.Sub(st.StartedAt)
+		})
+
+		if ctx.Err() != nil {
+			sp.update(func(st *SupervisorStatus) { st.State = SupervisorStopped })
+			return
+		}
+
+		if sp.spec.MaxRestarts > 0 && sp.Status().Restarts >= sp.spec.MaxRestarts {
+			sp.mtx.Lock()
+			sp.status.State = SupervisorFailed
+			sp.err = ers.Wrapf(erc.Join(ErrSupervisorFailed, err), "%q exceeded %d restarts", sp.spec.Name, sp.spec.MaxRestarts)
+			sp.mtx.Unlock()
+			fields := message.Fields{"supervisor": sp.spec.Name, "cmd": cmd, "restarts": sp.spec.MaxRestarts}
+			if err != nil {
+				fields["err"] = redaction.redactError(sp.spec.Command, err)
+			}
+			logger.Error(fields)
+			return
+		}
+
+		if sp.spec.ResetAfter > 0 && uptime >= sp.spec.ResetAfter {
+			consecutive = 1
+		}
+
+		delay := backoff.delay(consecutive)
+		if restarted {
+			delay = 0
+		}
+
+		fields := message.Fields{
+			"supervisor": sp.spec.Name,
+			"cmd":        cmd,
+			"uptime":     uptime,
+			"delay":      delay,
+			"requested":  restarted,
+		}
+		if err != nil {
+			fields["err"] = redaction.redactError(sp.spec.Command, err)
+		}
+		logger.Notice(fields)
+
+		sp.update(func(st *SupervisorStatus) { st.State = SupervisorBackoff })
+		select {
+		case <-ctx.Done():
+			sp.update(func(st *SupervisorStatus) { st.State = SupervisorStopped })
+			return
+		case <-time.After(delay):
+		}
+
+		sp.update(func(st *SupervisorStatus) { st.Restarts++ })
+	}
+}
+
+// SupervisorGroup manages a set of supervised processes by name.
+// The zero value is ready to use.
+type SupervisorGroup struct {
+	mtx   sync.Mutex
+	procs map[string]*SupervisedProcess
+}
+
+// NewSupervisorGroup constructs an empty SupervisorGroup.
+func NewSupervisorGroup() *SupervisorGroup { return &SupervisorGroup{} }
+
+// Start starts the supervisor and adds its process to the group. It
+// is an error to start a supervisor without a name, or with the name
+// of a process in the group that has not exited; processes that have
+// exited are replaced.
+func (g *SupervisorGroup) Start(ctx context.Context, s Supervisor) (*SupervisedProcess, error) {
+	if s.Name == "" {
+		return nil, ers.Wrap(ErrUndefinedOperation, "supervisor has no name")
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if g.procs == nil {
+		g.procs = map[string]*SupervisedProcess{}
+	}
+	if sp, ok := g.procs[s.Name]; ok && !isClosed(sp.done) {
+		return nil, ers.Wrapf(ErrSupervisorExists, "%q", s.Name)
+	}
+
+	sp := s.Start(ctx)
+	g.procs[s.Name] = sp
+	return sp, nil
+}
+
+// Get returns the process with the name, if it exists.
+func (g *SupervisorGroup) Get(name string) (*SupervisedProcess, bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	sp, ok := g.procs[name]
+	return sp, ok
+}
+
+// Statuses returns the status of every process in the group, in name
+// order.
+func (g *SupervisorGroup) Statuses() iter.Seq[SupervisorStatus] {
+	g.mtx.Lock()
+	procs := maps.Clone(g.procs)
+	g.mtx.Unlock()
+
+	return func(yield func(SupervisorStatus) bool) {
+		for _, name := range slices.Sorted(maps.Keys(procs)) {
+			if !yield(procs[name].Status()) {
+				return
+			}
+		}
+	}
+}
+
+// Stop stops the process with the name and removes it from the
+// group.
+func (g *SupervisorGroup) Stop(name string) error {
+	g.mtx.Lock()
+	sp, ok := g.procs[name]
+	delete(g.procs, name)
+	g.mtx.Unlock()
+
+	if !ok {
+		return ers.Wrapf(ErrUndefinedOperation, "no supervised process named %q", name)
+	}
+	return sp.Stop()
+}
+
+// Close stops every process in the group, waits for them to exit,
+// and removes them from the group.
+func (g *SupervisorGroup) Close() error {
+	g.mtx.Lock()
+	procs := g.procs
+	g.procs = nil
+	g.mtx.Unlock()
+
+	for _, sp := range procs {
+		sp.cancel()
+	}
+
+	ec := &erc.Collector{}
+	for _, name := range slices.Sorted(maps.Keys(procs)) {
+		ec.Push(procs[name].Wait())
+	}
+	return ec.Resolve()
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// tailBuffer is a concurrency-safe writer that retains the last size
+// bytes written to it.
+type tailBuffer struct {
+	mtx  sync.Mutex
+	size int
+	buf  []byte
+}
+
+func (tb *tailBuffer) Write(in []byte) (int, error) {
+	tb.mtx.Lock()
+	defer tb.mtx.Unlock()
+
+	tb.buf = append(tb.buf, in...)
+	if over := len(tb.buf) - tb.size; over > 0 {
+		tb.buf = append(tb.buf[:0], tb.buf[over:]...)
+	}
+	return len(in), nil
+}
+
+func (tb *tailBuffer) String() string {
+	tb.mtx.Lock()
+	defer tb.mtx.Unlock()
+	return string(tb.buf)
+}
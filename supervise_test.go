@@ -0,0 +1,156 @@
+package libfun
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/grip"
+	"github.com/tychoish/grip/send"
+)
+
+func TestSupervisor(t *testing.T) {
+	waitFor := func(t *testing.T, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for condition")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("Restart", func(t *testing.T) {
+		sp := Supervisor{
+			Name:    "echo",
+			Command: MakeCommand("sh", "-c", "echo tick"),
+			Delay:   time.Millisecond,
+		}.Start(testContext(t))
+
+		waitFor(t, func() bool { return sp.Status().Restarts >= 3 })
+		assert.NotError(t, sp.Stop())
+
+		st := sp.Status()
+		check.Equal(t, st.Name, "echo")
+		check.Equal(t, st.State, SupervisorStopped)
+		check.Substring(t, sp.Output(), "tick\ntick\ntick\n")
+	})
+	t.Run("MaxRestarts", func(t *testing.T) {
+		sp := Supervisor{
+			Name:        "fail",
+			Command:     MakeCommand("sh", "-c", "echo oops >&2; exit 3"),
+			Delay:       time.Millisecond,
+			MaxRestarts: 2,
+		}.Start(testContext(t))
+
+		err := sp.Wait()
+		assert.Error(t, err)
+		check.ErrorIs(t, err, ErrSupervisorFailed)
+		check.ErrorIs(t, err, ErrNonZeroExit)
+
+		st := sp.Status()
+		check.Equal(t, st.State, SupervisorFailed)
+		check.Equal(t, st.Restarts, 2)
+		var eo *ErrOutput
+		assert.True(t, errors.As(st.LastError, &eo))
+		check.Equal(t, eo.ExitCode, 3)
+		check.Equal(t, strings.Count(sp.Output(), "oops"), 3)
+	})
+	t.Run("Redaction", func(t *testing.T) {
+		sender := send.MakeInternal()
+		ctx := grip.WithLogger(testContext(t), grip.NewLogger(sender))
+
+		sp := Supervisor{
+			Name:        "secret",
+			Command:     MakeCommand("sh", "-c", `echo "$0" >&2; exit 1`, "--token=hunter2"),
+			Delay:       time.Millisecond,
+			MaxRestarts: 1,
+		}.Start(ctx)
+		check.ErrorIs(t, sp.Wait(), ErrSupervisorFailed)
+
+		// one restart and one failure.
+		assert.Equal(t, sender.Len(), 2)
+		for sender.Len() > 0 {
+			msg := sender.GetMessage().Rendered
+			check.Substring(t, msg, "--token="+Redacted)
+			check.NotSubstring(t, msg, "hunter2")
+		}
+	})
+	t.Run("Stop", func(t *testing.T) {
+		sp := Supervisor{Name: "sleep", Command: MakeCommand("sleep", "60")}.Start(testContext(t))
+		waitFor(t, func() bool { return sp.Status().State == SupervisorRunning })
+
+		start := time.Now()
+		assert.NotError(t, sp.Stop())
+		check.True(t, time.Since(start) < 10*time.Second)
+		check.Equal(t, sp.Status().State, SupervisorStopped)
+		check.Equal(t, sp.Status().Restarts, 0)
+	})
+	t.Run("Context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testContext(t))
+		sp := Supervisor{Name: "sleep", Command: MakeCommand("sleep", "60")}.Start(ctx)
+		cancel()
+		<-sp.Done()
+		check.Equal(t, sp.Status().State, SupervisorStopped)
+	})
+	t.Run("RestartRequested", func(t *testing.T) {
+		sp := Supervisor{
+			Name:    "sleep",
+			Command: MakeCommand("sleep", "60"),
+			Delay:   time.Hour,
+		}.Start(testContext(t))
+		waitFor(t, func() bool { return sp.Status().State == SupervisorRunning })
+
+		// a requested restart does not wait for the delay
+		sp.Restart()
+		waitFor(t, func() bool {
+			st := sp.Status()
+			return st.Restarts == 1 && st.State == SupervisorRunning
+		})
+		assert.NotError(t, sp.Stop())
+	})
+	t.Run("Group", func(t *testing.T) {
+		ctx := testContext(t)
+		group := NewSupervisorGroup()
+
+		_, err := group.Start(ctx, Supervisor{Command: MakeCommand("true")})
+		check.ErrorIs(t, err, ErrUndefinedOperation)
+
+		for _, name := range []string{"b", "a"} {
+			_, err := group.Start(ctx, Supervisor{Name: name, Command: MakeCommand("sleep", "60")})
+			assert.NotError(t, err)
+		}
+		_, err = group.Start(ctx, Supervisor{Name: "a", Command: MakeCommand("sleep", "60")})
+		check.ErrorIs(t, err, ErrSupervisorExists)
+
+		sp, ok := group.Get("a")
+		assert.True(t, ok)
+		waitFor(t, func() bool { return sp.Status().State == SupervisorRunning })
+
+		names := []string{}
+		for st := range group.Statuses() {
+			names = append(names, st.Name)
+		}
+		check.EqualItems(t, names, []string{"a", "b"})
+
+		assert.NotError(t, group.Stop("a"))
+		_, ok = group.Get("a")
+		check.True(t, !ok)
+		check.ErrorIs(t, group.Stop("a"), ErrUndefinedOperation)
+
+		// stopped processes can be replaced.
+		_, err = group.Start(ctx, Supervisor{Name: "a", Command: MakeCommand("sleep", "60")})
+		assert.NotError(t, err)
+
+		b, _ := group.Get("b")
+		assert.NotError(t, group.Close())
+		check.Equal(t, b.Status().State, SupervisorStopped)
+		check.Equal(t, len(slices.Collect(group.Statuses())), 0)
+	})
+}
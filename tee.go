@@ -0,0 +1,145 @@
+package libfun
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tychoish/grip/level"
+	"github.com/tychoish/grip/message"
+	"github.com/tychoish/grip/send"
+)
+
+// CommandTee copies the output of commands, as they write it, to
+// writers or a grip sender. Errors writing to the tee are ignored.
+// A tee may be shared by commands that run concurrently.
+type CommandTee struct {
+	// Stdout and Stderr, when specified, receive a copy of the
+	// standard output and standard error of the command. They may
+	// be the same writer, for example a RotatingFile.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Timestamps prefixes every line written to Stdout and Stderr
+	// with the time that the command wrote it, formatted with
+	// TimeFormat, which defaults to time.RFC3339Nano.
+	Timestamps bool
+	TimeFormat string
+	// Sender, when specified, receives every line of output as a
+	// message.Fields with the "cmd" (redacted as in audit
+	// records), "stream", and "line", at Level, which defaults to
+	// level.Info.
+	Sender send.Sender
+	Level  level.Priority
+
+	// all streams of all commands that use the tee share the
+	// mutex, so that lines written to the same writer are not
+	// interleaved.
+	mtx sync.Mutex
+}
+
+type teeCtxKey struct{}
+
+// WithTee attaches a CommandTee to the context, for all commands
+// (including RunCommand and Ripgrep) run with the context.
+func WithTee(ctx context.Context, tee *CommandTee) context.Context {
+	return context.WithValue(ctx, teeCtxKey{}, tee)
+}
+
+func (c Command) tee(ctx context.Context) *CommandTee {
+	if c.Tee != nil {
+		return c.Tee
+	}
+	if tee, ok := ctx.Value(teeCtxKey{}).(*CommandTee); ok {
+		return tee
+	}
+	return nil
+}
+
+// wrap returns writers that write to stdout and stderr and the tee,
+// and a function that flushes the final lines of output, if they do
+// not end with a newline, after the command exits.
+func (ct *CommandTee) wrap(cmd string, stdout, stderr io.Writer) (io.Writer, io.Writer, func()) {
+	outStream := &teeStream{tee: ct, cmd: cmd, name: "stdout", out: ct.Stdout}
+	errStream := &teeStream{tee: ct, cmd: cmd, name: "stderr", out: ct.Stderr}
+
+	return io.MultiWriter(stdout, outStream), io.MultiWriter(stderr, errStream), func() {
+		outStream.flush()
+		errStream.flush()
+	}
+}
+
+// teeStream writes one stream of output to the tee.
+type teeStream struct {
+	tee  *CommandTee
+	cmd  string
+	name string
+	out  io.Writer
+	buf  []byte
+}
+
+func (ts *teeStream) lines() bool {
+	return ts.tee.Sender != nil || (ts.out != nil && ts.tee.Timestamps)
+}
+
+func (ts *teeStream) Write(in []byte) (int, error) {
+	ts.tee.mtx.Lock()
+	defer ts.tee.mtx.Unlock()
+
+	if ts.out != nil && !ts.tee.Timestamps {
+		_, _ = ts.out.Write(in)
+	}
+
+	if ts.lines() {
+		ts.buf = append(ts.buf, in...)
+		rest := ts.buf
+		for {
+			idx := bytes.IndexByte(rest, '\n')
+			if idx < 0 {
+				break
+			}
+			ts.line(rest[:idx])
+			rest = rest[idx+1:]
+		}
+		ts.buf = append(ts.buf[:0], rest...)
+	}
+
+	return len(in), nil
+}
+
+func (ts *teeStream) flush() {
+	ts.tee.mtx.Lock()
+	defer ts.tee.mtx.Unlock()
+	if len(ts.buf) > 0 {
+		ts.line(ts.buf)
+		ts.buf = nil
+	}
+}
+
+func (ts *teeStream) line(line []byte) {
+	if ts.out != nil && ts.tee.Timestamps {
+		format := ts.tee.TimeFormat
+		if format == "" {
+			format = time.RFC3339Nano
+		}
+
+		buf := make([]byte, 0, len(format)+len(line)+2)
+		buf = time.Now().AppendFormat(buf, format)
+		buf = append(buf, ' ')
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+		_, _ = ts.out.Write(buf)
+	}
+
+	if ts.tee.Sender != nil {
+		priority := ts.tee.Level
+		if priority == level.Invalid {
+			priority = level.Info
+		}
+
+		msg := message.MakeFields(message.Fields{"cmd": ts.cmd, "stream": ts.name, "line": string(line)})
+		msg.SetPriority(priority)
+		ts.tee.Sender.Send(msg)
+	}
+}
@@ -0,0 +1,155 @@
+package libfun
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+	"github.com/tychoish/grip/level"
+	"github.com/tychoish/grip/message"
+	"github.com/tychoish/grip/send"
+)
+
+type lockedBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(in []byte) (int, error) {
+	lb.mtx.Lock()
+	defer lb.mtx.Unlock()
+	return lb.buf.Write(in)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mtx.Lock()
+	defer lb.mtx.Unlock()
+	return lb.buf.String()
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken writer") }
+
+func TestCommandTee(t *testing.T) {
+	t.Run("Writers", func(t *testing.T) {
+		stdout, stderr := &lockedBuffer{}, &lockedBuffer{}
+		cmd := MakeCommand("sh", "-c", "echo one; echo two; echo oops >&2")
+		cmd.Tee = &CommandTee{Stdout: stdout, Stderr: stderr}
+
+		out, err := cmd.Lines(testContext(t))
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(out), []string{"one", "two"})
+		check.Equal(t, stdout.String(), "one\ntwo\n")
+		check.Equal(t, stderr.String(), "oops\n")
+	})
+	t.Run("Timestamps", func(t *testing.T) {
+		log := &lockedBuffer{}
+		cmd := MakeCommand("printf", "one\ntwo\npartial")
+		cmd.Tee = &CommandTee{Stdout: log, Timestamps: true, TimeFormat: time.DateTime}
+
+		out, err := cmd.Output(testContext(t))
+		assert.NotError(t, err)
+		check.Equal(t, string(out), "one\ntwo\npartial")
+
+		lines := strings.Split(strings.TrimSuffix(log.String(), "\n"), "\n")
+		assert.Equal(t, len(lines), 3)
+		stamp := regexp.MustCompile(`^\d{4}-\d\d-\d\d \d\d:\d\d:\d\d `)
+		for idx, want := range []string{"one", "two", "partial"} {
+			check.True(t, stamp.MatchString(lines[idx]))
+			check.Equal(t, stamp.ReplaceAllString(lines[idx], ""), want)
+		}
+	})
+	t.Run("Sender", func(t *testing.T) {
+		sender := send.MakeInternal()
+		cmd := MakeCommand("sh", "-c", "echo out; echo err >&2", "--token=hunter2")
+		cmd.Tee = &CommandTee{Sender: sender, Level: level.Notice}
+		assert.NotError(t, cmd.Run(testContext(t)))
+
+		assert.Equal(t, sender.Len(), 2)
+		streams := map[string]string{}
+		for sender.HasMessage() {
+			msg := sender.GetMessage()
+			check.Equal(t, msg.Priority, level.Notice)
+			fields := msg.Message.Raw().(message.Fields)
+			check.Equal(t, fields["cmd"].(string), "sh -c echo out; echo err >&2 --token="+Redacted)
+			streams[fields["stream"].(string)] = fields["line"].(string)
+		}
+		check.Equal(t, streams["stdout"], "out")
+		check.Equal(t, streams["stderr"], "err")
+	})
+	t.Run("Errors", func(t *testing.T) {
+		cmd := MakeCommand("echo", "hello")
+		cmd.Tee = &CommandTee{Stdout: failingWriter{}, Timestamps: true}
+		out, err := cmd.Lines(testContext(t))
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(out), []string{"hello"})
+	})
+	t.Run("Context", func(t *testing.T) {
+		log := &lockedBuffer{}
+		ctx := WithTee(testContext(t), &CommandTee{Stdout: log})
+
+		out, err := RunCommand(ctx, "echo 'from context'")
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(out), []string{"from context"})
+		check.Equal(t, log.String(), "from context\n")
+
+		// the command's tee takes precedence
+		other := &lockedBuffer{}
+		cmd := MakeCommand("echo", "other")
+		cmd.Tee = &CommandTee{Stdout: other}
+		assert.NotError(t, cmd.Run(ctx))
+		check.Equal(t, other.String(), "other\n")
+		check.Equal(t, log.String(), "from context\n")
+	})
+	t.Run("Concurrent", func(t *testing.T) {
+		// the tee is shared by all of the commands, and the
+		// buffer is not safe for concurrent use on its own.
+		var log bytes.Buffer
+		sender := send.MakeInternal()
+		ctx := WithTee(testContext(t), &CommandTee{Stdout: &log, Stderr: &log, Sender: sender})
+
+		cmds := make([]Command, 8)
+		for idx := range cmds {
+			cmds[idx] = MakeCommand("sh", "-c", "for i in 1 2 3 4 5 6 7 8 9 10; do echo out-$i; echo err-$i >&2; done")
+		}
+		for res := range RunCommands(ctx, nil, irt.Slice(cmds), 4) {
+			check.NotError(t, res.Err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+		check.Equal(t, len(lines), 160)
+		for _, line := range lines {
+			check.True(t, regexp.MustCompile(`^(out|err)-[0-9]+$`).MatchString(line))
+		}
+		check.Equal(t, sender.Len(), 160)
+	})
+	t.Run("Stream", func(t *testing.T) {
+		log := &lockedBuffer{}
+		cmd := MakeCommand("sh", "-c", "echo a; echo b")
+		cmd.Tee = &CommandTee{Stdout: log}
+
+		lines := []string{}
+		for line, err := range cmd.Stream(testContext(t)) {
+			assert.NotError(t, err)
+			lines = append(lines, line)
+		}
+		check.EqualItems(t, lines, []string{"a", "b"})
+		check.Equal(t, log.String(), "a\nb\n")
+	})
+	t.Run("Limits", func(t *testing.T) {
+		log := &lockedBuffer{}
+		cmd := MakeCommand("yes")
+		cmd.Limits = OutputLimits{StdoutLines: 2}
+		cmd.Tee = &CommandTee{Stdout: log}
+		check.ErrorIs(t, cmd.Run(testContext(t)), ErrOutputLimitExceeded)
+		check.Equal(t, log.String(), "y\ny\n")
+	})
+}
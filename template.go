@@ -0,0 +1,193 @@
+package libfun
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/google/shlex"
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/ers"
+)
+
+// ErrTemplateArgument is returned when a CommandTemplate cannot
+// produce an argument, because a placeholder refers to a value that
+// the data does not provide, produces an empty argument, or produces
+// an argument that would be interpreted as an option.
+const ErrTemplateArgument ers.Error = "invalid command template argument"
+
+var templatePlaceholder = regexp.MustCompile(`\x{E000}([0-9]+)\x{E001}`)
+
+// CommandTemplate produces commands from a template that contains
+// text/template placeholders (e.g. "rg --files {{.path}}"). The
+// template is split into arguments, using shell quoting rules,
+// before the placeholders are filled, so every value becomes part of
+// exactly one argument, regardless of the spaces, quotes, or other
+// shell syntax it contains. Like ParseCommand, no shell is involved
+// in running the resulting commands.
+//
+// Placeholders must be filled: referring to a key that is missing
+// from a map, or producing an empty argument from an argument that
+// consists only of placeholders, is an error. To prevent values from
+// injecting options, arguments that begin with a placeholder must
+// not begin with "-".
+type CommandTemplate struct {
+	source  string
+	args    []*template.Template
+	filled  []bool
+	leading []bool
+}
+
+// ParseCommandTemplate parses a command template. In addition to the
+// standard text/template functions, templates can use "quote", which
+// quotes its argument with ShellQuote, for commands that pass a
+// script to a shell (e.g. sh -c "ls {{quote .dir}}").
+func ParseCommandTemplate(tmpl string) (*CommandTemplate, error) {
+	funcs := template.FuncMap{"quote": ShellQuote}
+
+	parsed, err := template.New("command").Funcs(funcs).Parse(tmpl)
+	if err != nil {
+		return nil, ers.Wrapf(err, "parsing command template %q", tmpl)
+	}
+
+	// replace the actions with placeholders that the shell
+	// splitter treats as part of a word, so that spaces and
+	// quotes in actions do not split arguments.
+	var (
+		actions   []string
+		protected strings.Builder
+	)
+	if parsed.Tree != nil {
+		for _, node := range parsed.Tree.Root.Nodes {
+			if text, ok := node.(*parse.TextNode); ok {
+				protected.Write(text.Text)
+				continue
+			}
+			fmt.Fprintf(&protected, "\uE000%d\uE001", len(actions))
+			actions = append(actions, node.String())
+		}
+	}
+
+	words, err := shlex.Split(protected.String())
+	if err != nil {
+		return nil, ers.Wrapf(err, "parsing command template %q", tmpl)
+	}
+	if len(words) == 0 {
+		return nil, ers.Wrapf(ErrUndefinedOperation, "empty command template %q", tmpl)
+	}
+
+	ct := &CommandTemplate{
+		source:  tmpl,
+		args:    make([]*template.Template, len(words)),
+		filled:  make([]bool, len(words)),
+		leading: make([]bool, len(words)),
+	}
+	for idx, word := range words {
+		ct.filled[idx] = templatePlaceholder.ReplaceAllString(word, "") == "" && word != ""
+		ct.leading[idx] = strings.HasPrefix(word, "\uE000")
+		word = templatePlaceholder.ReplaceAllStringFunc(word, func(ph string) string {
+			n, _ := strconv.Atoi(templatePlaceholder.FindStringSubmatch(ph)[1])
+			return actions[n]
+		})
+
+		ct.args[idx], err = template.New(fmt.Sprint("arg", idx)).
+			Option("missingkey=error").
+			Funcs(funcs).
+			Parse(word)
+		if err != nil {
+			return nil, ers.Wrapf(err, "parsing command template %q", tmpl)
+		}
+	}
+
+	return ct, nil
+}
+
+// String returns the source of the template.
+func (ct *CommandTemplate) String() string { return ct.source }
+
+// Args fills the template with the data, typically a map or struct,
+// and returns the arguments of the command.
+func (ct *CommandTemplate) Args(data any) ([]string, error) {
+	out := make([]string, len(ct.args))
+	buf := &strings.Builder{}
+	for idx, tmpl := range ct.args {
+		buf.Reset()
+		if err := tmpl.Execute(buf, data); err != nil {
+			return nil, ers.Wrapf(erc.Join(ErrTemplateArgument, err), "argument %d of %q", idx, ct.source)
+		}
+		arg := buf.String()
+		switch {
+		case ct.filled[idx] && arg == "":
+			return nil, ers.Wrapf(ErrTemplateArgument, "argument %d of %q is empty", idx, ct.source)
+		case ct.leading[idx] && strings.HasPrefix(arg, "-"):
+			return nil, ers.Wrapf(ErrTemplateArgument, "argument %d of %q begins with %q", idx, ct.source, "-")
+		}
+		out[idx] = arg
+	}
+	return out, nil
+}
+
+// Command fills the template with the data and returns the
+// equivalent Command.
+func (ct *CommandTemplate) Command(data any) (Command, error) {
+	args, err := ct.Args(data)
+	if err != nil {
+		return Command{}, err
+	}
+	return MakeCommand(args...), nil
+}
+
+// RunCommandTemplate is the same as RunCommand, but constructs the
+// command from a CommandTemplate filled with the data, rather than
+// by formatting a command string, so that values with spaces and
+// quotes (e.g. paths or dmenu selections) are passed to the command
+// as single arguments.
+func RunCommandTemplate(ctx context.Context, tmpl string, data any) (iter.Seq[string], error) {
+	ct, err := ParseCommandTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ct.Command(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Lines(ctx)
+}
+
+// ShellQuote quotes the string so that a POSIX shell interprets it
+// as a single word with the same value. Strings that contain only
+// characters without special meaning are returned unchanged.
+func ShellQuote(in string) string {
+	if in != "" && strings.IndexFunc(in, needsShellQuote) == -1 {
+		return in
+	}
+	return "'" + strings.ReplaceAll(in, "'", `'\''`) + "'"
+}
+
+// ShellJoin quotes each argument with ShellQuote, and joins them with
+// spaces, producing a command string that ParseCommand (or a shell)
+// splits into the original arguments.
+func ShellJoin(args []string) string {
+	out := make([]string, len(args))
+	for idx, arg := range args {
+		out[idx] = ShellQuote(arg)
+	}
+	return strings.Join(out, " ")
+}
+
+func needsShellQuote(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	case strings.ContainsRune("-_./:=@%+,", r):
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,121 @@
+package libfun
+
+import (
+	"testing"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/irt"
+)
+
+func TestCommandTemplate(t *testing.T) {
+	args := func(t *testing.T, tmpl string, data any) []string {
+		t.Helper()
+		ct, err := ParseCommandTemplate(tmpl)
+		assert.NotError(t, err)
+		out, err := ct.Args(data)
+		assert.NotError(t, err)
+		return out
+	}
+
+	t.Run("Quoting", func(t *testing.T) {
+		check.EqualItems(t,
+			args(t, `rg --files {{.path}}`, map[string]string{"path": "/tmp/my dir/it's \"here\""}),
+			[]string{"rg", "--files", "/tmp/my dir/it's \"here\""},
+		)
+		// values are never split or interpreted by the shell
+		check.EqualItems(t,
+			args(t, `echo {{.sel}}`, map[string]string{"sel": "a; rm -rf / # $(whoami)"}),
+			[]string{"echo", "a; rm -rf / # $(whoami)"},
+		)
+	})
+	t.Run("Combined", func(t *testing.T) {
+		check.EqualItems(t,
+			args(t, `cp --target={{.Dir}} "{{.Name}} copy" 10 {{ printf "%s.bak" .Name }}`, struct{ Dir, Name string }{"a b", "c d"}),
+			[]string{"cp", "--target=a b", "c d copy", "10", "c d.bak"},
+		)
+	})
+	t.Run("Actions", func(t *testing.T) {
+		// actions are parsed by text/template, so delimiters in
+		// strings and control structures are part of one action.
+		check.EqualItems(t,
+			args(t, `echo {{ "}} x" }} {{if .x}}a b{{else}}c{{end}}`, map[string]bool{"x": true}),
+			[]string{"echo", "}} x", "a b"},
+		)
+		check.EqualItems(t,
+			args(t, `echo {{/* comment */}}{{.x}}`, map[string]string{"x": "y"}),
+			[]string{"echo", "y"},
+		)
+	})
+	t.Run("Struct", func(t *testing.T) {
+		check.EqualItems(t,
+			args(t, `git -C {{.Dir}} log -n {{.Count}}`, struct {
+				Dir   string
+				Count int
+			}{Dir: "~/src/my repo", Count: 3}),
+			[]string{"git", "-C", "~/src/my repo", "log", "-n", "3"},
+		)
+	})
+	t.Run("Quote", func(t *testing.T) {
+		out := args(t, `sh -c "ls {{quote .dir}}"`, map[string]string{"dir": "it's here"})
+		check.EqualItems(t, out, []string{"sh", "-c", `ls 'it'\''s here'`})
+
+		check.Equal(t, ShellQuote("plain/path-1.go"), "plain/path-1.go")
+		check.Equal(t, ShellQuote(""), "''")
+		check.Equal(t, ShellQuote("a b"), "'a b'")
+
+		in := []string{"echo", "a b", "it's", `"quoted"`, "", "$HOME"}
+		c, err := ParseCommand(ShellJoin(in))
+		assert.NotError(t, err)
+		check.EqualItems(t, c.Args, in)
+	})
+	t.Run("Validation", func(t *testing.T) {
+		ct, err := ParseCommandTemplate(`rm -rf {{.dir}}`)
+		assert.NotError(t, err)
+
+		_, err = ct.Args(map[string]string{})
+		check.ErrorIs(t, err, ErrTemplateArgument)
+		_, err = ct.Args(map[string]string{"dir": ""})
+		check.ErrorIs(t, err, ErrTemplateArgument)
+		_, err = ct.Args(struct{ Other string }{})
+		check.ErrorIs(t, err, ErrTemplateArgument)
+		_, err = ct.Args(nil)
+		check.ErrorIs(t, err, ErrTemplateArgument)
+
+		// values cannot inject options.
+		_, err = ct.Args(map[string]string{"dir": "--no-preserve-root"})
+		check.ErrorIs(t, err, ErrTemplateArgument)
+		_, err = ct.Args(map[string]string{"dir": "-"})
+		check.ErrorIs(t, err, ErrTemplateArgument)
+		check.EqualItems(t, args(t, `rm -rf -- ./{{.dir}}`, map[string]string{"dir": "-x"}), []string{"rm", "-rf", "--", "./-x"})
+		check.EqualItems(t, args(t, `grep --regexp={{.re}}`, map[string]string{"re": "-x"}), []string{"grep", "--regexp=-x"})
+
+		// values that resemble missing values are valid.
+		check.EqualItems(t, args(t, `echo {{.x}}`, map[string]string{"x": "<no value>"}), []string{"echo", "<no value>"})
+
+		// literal empty arguments are allowed
+		check.EqualItems(t, args(t, `printf "" {{.x}}`, map[string]string{"x": "y"}), []string{"printf", "", "y"})
+	})
+	t.Run("ParseErrors", func(t *testing.T) {
+		_, err := ParseCommandTemplate("")
+		check.ErrorIs(t, err, ErrUndefinedOperation)
+		_, err = ParseCommandTemplate("echo {{.x")
+		check.Error(t, err)
+		_, err = ParseCommandTemplate("echo {{if .x}}")
+		check.Error(t, err)
+		_, err = ParseCommandTemplate("echo {{undefined .x}}")
+		check.Error(t, err)
+		ct, err := ParseCommandTemplate("echo {{.x}}")
+		assert.NotError(t, err)
+		check.Equal(t, ct.String(), "echo {{.x}}")
+	})
+	t.Run("Run", func(t *testing.T) {
+		ctx := testContext(t)
+		out, err := RunCommandTemplate(ctx, `printf '%s\n' {{.a}} {{.b}}`, map[string]string{"a": "one two", "b": "three"})
+		assert.NotError(t, err)
+		check.EqualItems(t, irt.Collect(out), []string{"one two", "three"})
+
+		_, err = RunCommandTemplate(ctx, `echo {{.missing}}`, map[string]string{})
+		check.ErrorIs(t, err, ErrTemplateArgument)
+	})
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package libfun
+
+import (
+	"os/exec"
+	"time"
+)
+
+// terminateGroup is a no-op on platforms without process groups,
+// where canceling the context kills the process.
+func terminateGroup(*exec.Cmd, time.Duration) func() { return func() {} }
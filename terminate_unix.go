@@ -0,0 +1,71 @@
+//go:build unix
+
+package libfun
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// terminateGroup runs the command in its own process group, and,
+// when the command's context is canceled, sends SIGTERM to the
+// group, followed by SIGKILL if the group has not exited after the
+// grace period. Signaling the group, rather than the process,
+// terminates the children and grandchildren of the process (e.g. the
+// subtree of sh -c), which would otherwise outlive it, and keep its
+// output open. The returned function must be called after the
+// command exits, and prevents signaling a group that was never
+// signaled; groups that were signaled receive SIGKILL at the end of
+// the grace period if any of their processes remain, even when the
+// command itself has exited.
+func terminateGroup(cmd *exec.Cmd, grace time.Duration) func() {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	var (
+		mtx  sync.Mutex
+		done bool
+	)
+
+	signal := func(pgid int, sig syscall.Signal) error {
+		if err := syscall.Kill(-pgid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+		return nil
+	}
+
+	cmd.Cancel = func() error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if done {
+			return os.ErrProcessDone
+		}
+
+		pgid := cmd.Process.Pid
+		if grace <= 0 {
+			return signal(pgid, syscall.SIGKILL)
+		}
+
+		// a process group persists while any of its processes
+		// remain, so the group is probed rather than the
+		// command, which may have exited after the SIGTERM.
+		time.AfterFunc(grace, func() {
+			if syscall.Kill(-pgid, 0) == nil {
+				_ = signal(pgid, syscall.SIGKILL)
+			}
+		})
+		return signal(pgid, syscall.SIGTERM)
+	}
+
+	return func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		done = true
+	}
+}
@@ -0,0 +1,118 @@
+//go:build unix
+
+package libfun
+
+import (
+	"context"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/grip/send"
+)
+
+func TestTermination(t *testing.T) {
+	exited := func(t *testing.T, pid int) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for syscall.Kill(pid, 0) == nil {
+			if time.Now().After(deadline) {
+				t.Fatalf("process %d did not exit", pid)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("Grandchildren", func(t *testing.T) {
+		cmd := MakeCommand("sh", "-c", "sleep 60 & echo $!; sleep 60 & echo $!; wait")
+
+		pids := []int{}
+		for line, err := range cmd.Stream(testContext(t)) {
+			assert.NotError(t, err)
+			pid, err := strconv.Atoi(line)
+			assert.NotError(t, err)
+			pids = append(pids, pid)
+			if len(pids) == 2 {
+				break
+			}
+		}
+		for _, pid := range pids {
+			exited(t, pid)
+		}
+	})
+	t.Run("IgnoredTerm", func(t *testing.T) {
+		// the grandchild ignores SIGTERM (once the trap is set),
+		// and does not hold the output open, so it outlives the
+		// shell unless the group is killed after the grace
+		// period.
+		cmd := MakeCommand("sh", "-c", `sh -c 'trap "" TERM; exec sleep 30' >/dev/null 2>&1 & sleep 0.2; echo $!; wait`)
+		cmd.KillGrace = 300 * time.Millisecond
+
+		pid := 0
+		for line, err := range cmd.Stream(testContext(t)) {
+			assert.NotError(t, err)
+			pid, err = strconv.Atoi(line)
+			assert.NotError(t, err)
+			break
+		}
+		assert.True(t, pid > 0)
+		exited(t, pid)
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		cmd := MakeCommand("sh", "-c", "sleep 60 & sleep 60 & wait")
+		cmd.Timeout = 50 * time.Millisecond
+
+		start := time.Now()
+		err := cmd.Run(testContext(t))
+		check.True(t, time.Since(start) < DefaultKillGrace)
+		check.ErrorIs(t, err, ErrCommandTimeout)
+		check.Equal(t, errOutput(t, err).Signal, syscall.SIGTERM)
+	})
+	t.Run("Escalation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testContext(t))
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		cmd := MakeCommand("sh", "-c", "trap '' TERM; sleep 60 & wait")
+		cmd.KillGrace = 250 * time.Millisecond
+
+		start := time.Now()
+		err := cmd.Run(ctx)
+		check.True(t, time.Since(start) >= cmd.KillGrace)
+		check.True(t, time.Since(start) < DefaultKillGrace)
+		check.ErrorIs(t, err, ErrCommandCanceled)
+		check.Equal(t, errOutput(t, err).Signal, syscall.SIGKILL)
+	})
+	t.Run("Immediate", func(t *testing.T) {
+		cmd := MakeCommand("sleep", "60")
+		cmd.Timeout = 50 * time.Millisecond
+		cmd.KillGrace = -1
+		err := cmd.Run(testContext(t))
+		check.ErrorIs(t, err, ErrCommandTimeout)
+		check.Equal(t, errOutput(t, err).Signal, syscall.SIGKILL)
+	})
+	t.Run("OutputLimit", func(t *testing.T) {
+		// the sleep keeps the output open after the shell
+		// exits, unless the group is terminated.
+		cmd := MakeCommand("sh", "-c", "printf 0123456789; sleep 60")
+		cmd.Limits = OutputLimits{StdoutBytes: 4}
+		sender := send.MakeInternal()
+		cmd.Audit = &CommandAudit{Sender: sender}
+
+		start := time.Now()
+		err := cmd.Run(testContext(t))
+		check.True(t, time.Since(start) < DefaultKillGrace)
+		check.ErrorIs(t, err, ErrOutputLimitExceeded)
+		check.Equal(t, errOutput(t, err).Signal, syscall.SIGTERM)
+		check.Substring(t, sender.GetMessage().Rendered, "signal='terminated'")
+	})
+	t.Run("Completed", func(t *testing.T) {
+		// commands that exit normally are not signaled.
+		cmd := MakeCommand("sh", "-c", "exit 2")
+		err := cmd.Run(testContext(t))
+		check.ErrorIs(t, err, ErrNonZeroExit)
+		check.Equal(t, errOutput(t, err).Signal, 0)
+	})
+}
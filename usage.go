@@ -0,0 +1,91 @@
+package libfun
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/tychoish/grip"
+	"github.com/tychoish/grip/level"
+	"github.com/tychoish/grip/message"
+	"github.com/tychoish/jasper"
+)
+
+// ResourceUsage reports the resources that a command consumed. The
+// CPU times and MaxRSS are only available for commands run as local
+// processes by the JasperExecutor, and are otherwise zero.
+type ResourceUsage struct {
+	// Wall is the elapsed time between starting the command and
+	// its exit.
+	Wall time.Duration
+	// User and System are the CPU time that the process spent
+	// in user and kernel mode.
+	User   time.Duration
+	System time.Duration
+	// MaxRSS is the maximum resident set size of the process in
+	// bytes, and is only reported on Linux.
+	MaxRSS int64
+	// StdoutBytes and StderrBytes are the number of bytes that
+	// the command wrote to its standard output and standard
+	// error.
+	StdoutBytes int
+	StderrBytes int
+}
+
+// execReport collects the details of a single execution of a
+// command. Command.exec attaches a report to the context, so that
+// executors can report details that are not available to Command.
+type execReport struct {
+	Process jasper.ProcessInfo
+	Usage   ResourceUsage
+}
+
+type reportCtxKey struct{}
+
+// withReport returns the report attached to the context, attaching a
+// new report if there is none.
+func withReport(ctx context.Context) (context.Context, *execReport) {
+	if report, ok := ctx.Value(reportCtxKey{}).(*execReport); ok {
+		return ctx, report
+	}
+	report := &execReport{}
+	return context.WithValue(ctx, reportCtxKey{}, report), report
+}
+
+func (u *ResourceUsage) setProcessState(ps *os.ProcessState) {
+	if ps == nil {
+		return
+	}
+	u.User = ps.UserTime()
+	u.System = ps.SystemTime()
+	u.MaxRSS = maxRSS(ps)
+}
+
+func (c Command) logUsage(ctx context.Context, report *execReport, err error) {
+	if c.LogUsage == level.Invalid {
+		return
+	}
+
+	// usage records are redacted in the same way as audit
+	// records.
+	audit := c.redaction(ctx)
+
+	fields := message.Fields{
+		"cmd":          audit.redactCommand(c.Args),
+		"wall":         report.Usage.Wall,
+		"user":         report.Usage.User,
+		"sys":          report.Usage.System,
+		"max_rss":      report.Usage.MaxRSS,
+		"stdout_bytes": report.Usage.StdoutBytes,
+		"stderr_bytes": report.Usage.StderrBytes,
+		"exit_code":    exitCode(err),
+	}
+	if report.Process.ID != "" {
+		fields["proc_id"] = report.Process.ID
+	}
+	if err != nil {
+		fields["err"] = audit.redactError(c, err)
+	}
+
+	grip.Context(ctx).Log(c.LogUsage, fields)
+}
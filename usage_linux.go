@@ -0,0 +1,15 @@
+package libfun
+
+import (
+	"os"
+	"syscall"
+)
+
+func maxRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		// linux reports the maximum resident set size in
+		// kilobytes.
+		return ru.Maxrss * 1024
+	}
+	return 0
+}
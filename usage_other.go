@@ -0,0 +1,7 @@
+//go:build !linux
+
+package libfun
+
+import "os"
+
+func maxRSS(*os.ProcessState) int64 { return 0 }
@@ -0,0 +1,83 @@
+package libfun
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/grip"
+	"github.com/tychoish/grip/level"
+	"github.com/tychoish/grip/send"
+)
+
+func TestResourceUsage(t *testing.T) {
+	t.Run("Output", func(t *testing.T) {
+		res := MakeCommand("sh", "-c", "printf abc; printf de >&2").Result(testContext(t))
+		assert.NotError(t, res.Err)
+		check.Equal(t, res.Usage.StdoutBytes, 3)
+		check.Equal(t, res.Usage.StderrBytes, 2)
+		check.True(t, res.Usage.Wall > 0)
+		check.True(t, res.Usage.Wall <= res.Duration)
+	})
+	t.Run("CPU", func(t *testing.T) {
+		res := MakeCommand("sh", "-c", "i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done").Result(testContext(t))
+		assert.NotError(t, res.Err)
+		check.True(t, res.Usage.User+res.Usage.System > 0)
+		if runtime.GOOS == "linux" {
+			check.True(t, res.Usage.MaxRSS > 0)
+		}
+	})
+	t.Run("Failure", func(t *testing.T) {
+		res := MakeCommand("sh", "-c", "printf abcd; exit 1").Result(testContext(t))
+		assert.Error(t, res.Err)
+		check.Equal(t, res.Usage.StdoutBytes, 4)
+		check.True(t, res.Usage.Wall > 0)
+	})
+	t.Run("Executor", func(t *testing.T) {
+		cmd := MakeCommand("libfun-command-does-not-exist")
+		cmd.Executor = ExecutorFunc(func(_ context.Context, _ Command, stdout, _ io.Writer) error {
+			_, err := io.WriteString(stdout, "fake\n")
+			return err
+		})
+		res := cmd.Result(t.Context())
+		assert.NotError(t, res.Err)
+		check.Equal(t, res.Usage.StdoutBytes, 5)
+		check.Equal(t, res.Usage.User, 0)
+		check.Equal(t, res.Process.ID, "")
+	})
+	t.Run("Logging", func(t *testing.T) {
+		sender := send.MakeInternal()
+		ctx := grip.WithLogger(testContext(t), grip.NewLogger(sender))
+
+		cmd := MakeCommand("echo", "hello")
+		cmd.LogUsage = level.Info
+		assert.NotError(t, cmd.Run(ctx))
+
+		assert.Equal(t, sender.Len(), 1)
+		msg := sender.GetMessage()
+		check.Equal(t, msg.Priority, level.Info)
+		check.Substring(t, msg.Rendered, "cmd='echo hello'")
+		check.Substring(t, msg.Rendered, "stdout_bytes='6'")
+
+		cmd.LogUsage = level.Invalid
+		assert.NotError(t, cmd.Run(ctx))
+		check.Equal(t, sender.Len(), 0)
+	})
+	t.Run("Redaction", func(t *testing.T) {
+		sender := send.MakeInternal()
+		ctx := grip.WithLogger(testContext(t), grip.NewLogger(sender))
+
+		cmd := MakeCommand("sh", "-c", "echo $1 >&2; exit 1", "sh", "--token", "hunter2")
+		cmd.LogUsage = level.Info
+		assert.Error(t, cmd.Run(ctx))
+
+		assert.Equal(t, sender.Len(), 1)
+		msg := sender.GetMessage()
+		check.Substring(t, msg.Rendered, "--token "+Redacted)
+		check.Substring(t, msg.Rendered, "exit status 1")
+		check.NotSubstring(t, msg.Rendered, "hunter2")
+	})
+}
@@ -62,6 +62,10 @@ type FsWalkOptions struct {
 	SkipPermissionErrors bool
 	IgnorePrefix         string
 	IncludePrefixes      []string
+
+	// Errors, when specified, collects the errors from the walk
+	// and from the function, which are otherwise discarded.
+	Errors *erc.Collector
 }
 
 func hasAnyPrefix(str string, prefixes []string) bool {
@@ -73,8 +77,15 @@ func hasAnyPrefix(str string, prefixes []string) bool {
 	return false
 }
 
+// FsWalkStream walks the file tree at opts.Path, and yields the
+// non-nil values that fn returns. The walk continues after errors,
+// which are collected in opts.Errors; fn may return fs.SkipDir or
+// fs.SkipAll, as with filepath.WalkDir.
 func FsWalkStream[T any](opts FsWalkOptions, fn func(p string, d fs.DirEntry) (*T, error)) iter.Seq[T] {
-	ec := &erc.Collector{}
+	ec := opts.Errors
+	if ec == nil {
+		ec = &erc.Collector{}
+	}
 
 	if opts.IgnorePrefix != "" && strings.HasPrefix(opts.Path, opts.IgnorePrefix) && len(opts.Path) > 1 {
 		opts.IgnorePrefix = opts.IgnorePrefix[len(opts.Path)-1:]
@@ -83,17 +94,15 @@ func FsWalkStream[T any](opts FsWalkOptions, fn func(p string, d fs.DirEntry) (*
 	return func(yield func(T) bool) {
 		ec.Push(filepath.WalkDir(opts.Path, func(p string, d fs.DirEntry, err error) error {
 			switch {
-			case opts.IgnorePrefix != "" && strings.HasPrefix(p, opts.IgnorePrefix):
-				return nil
-			case opts.IgnoreMode != nil && stw.Deref(opts.IgnoreMode) == d.Type():
-				return nil
 			case err != nil && opts.SkipPermissionErrors && errors.Is(err, fs.ErrPermission):
 				return nil
-			case err != nil && ers.Is(fs.SkipAll, fs.SkipDir, ers.ErrCurrentOpAbort, ers.ErrCurrentOpSkip):
-				return nil
 			case err != nil:
 				ec.Push(err)
-				return fs.SkipAll
+				return nil
+			case opts.IgnorePrefix != "" && strings.HasPrefix(p, opts.IgnorePrefix):
+				return nil
+			case opts.IgnoreMode != nil && stw.Deref(opts.IgnoreMode) == d.Type():
+				return nil
 			case opts.OnlyMode != nil && stw.Deref(opts.OnlyMode) == d.Type():
 				fallthrough
 			case len(opts.IncludePrefixes) > 0 && hasAnyPrefix(p, opts.IncludePrefixes):
@@ -109,11 +118,15 @@ func FsWalkStream[T any](opts FsWalkOptions, fn func(p string, d fs.DirEntry) (*
 					}
 
 					return nil
-				case err != nil && ers.Is(fs.SkipAll, fs.SkipDir, ers.ErrCurrentOpAbort, ers.ErrCurrentOpSkip):
+				case ers.Is(err, fs.SkipAll, fs.SkipDir):
+					return err
+				case ers.Is(err, ers.ErrCurrentOpAbort):
+					return fs.SkipAll
+				case ers.Is(err, ers.ErrCurrentOpSkip):
 					return nil
 				default:
 					ec.Push(err)
-					return err
+					return nil
 				}
 			}
 		}))
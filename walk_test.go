@@ -0,0 +1,45 @@
+package libfun
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/tychoish/fun/assert"
+	"github.com/tychoish/fun/assert/check"
+	"github.com/tychoish/fun/erc"
+	"github.com/tychoish/fun/irt"
+)
+
+func TestFsWalkStream(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "", "skip/b.txt": "", "c.txt": "", "d.txt": ""})
+	errBroken := errors.New("broken")
+
+	ec := &erc.Collector{}
+	seq := FsWalkStream(FsWalkOptions{Path: root, Errors: ec}, func(p string, d fs.DirEntry) (*string, error) {
+		rel, err := filepath.Rel(root, p)
+		assert.NotError(t, err)
+		switch {
+		case d.IsDir() && rel == "skip":
+			return nil, fs.SkipDir
+		case d.IsDir():
+			return nil, nil
+		case rel == "c.txt":
+			return nil, errBroken
+		default:
+			return &rel, nil
+		}
+	})
+
+	// skipped directories are not errors, and the walk continues
+	// after errors.
+	check.EqualItems(t, irt.Collect(seq), []string{"a.txt", "d.txt"})
+	err := ec.Resolve()
+	check.ErrorIs(t, err, errBroken)
+	check.True(t, !errors.Is(err, fs.SkipDir))
+
+	ec = &erc.Collector{}
+	check.Equal(t, len(irt.Collect(FsWalkStream(FsWalkOptions{Path: filepath.Join(root, "missing"), Errors: ec}, func(p string, d fs.DirEntry) (*string, error) { return &p, nil }))), 0)
+	check.ErrorIs(t, ec.Resolve(), fs.ErrNotExist)
+}